@@ -0,0 +1,154 @@
+package codec
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+type userInfo struct {
+	Name string            `json:"name" msgpack:"name"`
+	Id   uint64            `json:"id" msgpack:"id"`
+	Tags []string          `json:"tags" msgpack:"tags"`
+	Ext  map[string]string `json:"ext" msgpack:"ext"`
+}
+
+func TestRoundTrip(t *testing.T) {
+	info := userInfo{Name: "nash", Id: 1, Tags: []string{"a", "b"}, Ext: map[string]string{"k": "v"}}
+	for _, codeTool := range []Codec{GobCodec{}, JsonCodec{}, MsgPackCodec{}} {
+		data, err := codeTool.Encode(info)
+		assert.Equal(t, nil, err)
+		var res userInfo
+		assert.Equal(t, nil, codeTool.Decode(data, &res))
+		assert.Equal(t, info, res)
+
+		data, err = codeTool.Encode([]int{1, 2, 3})
+		assert.Equal(t, nil, err)
+		var ids []int
+		assert.Equal(t, nil, codeTool.Decode(data, &ids))
+		assert.Equal(t, []int{1, 2, 3}, ids)
+	}
+}
+
+func TestProtobufRoundTrip(t *testing.T) {
+	codeTool := ProtobufCodec{}
+	msg, err := structpb.NewStruct(map[string]interface{}{"name": "nash", "id": 1.0, "tags": []interface{}{"a", "b"}})
+	assert.Equal(t, nil, err)
+
+	data, err := codeTool.Encode(msg)
+	assert.Equal(t, nil, err)
+
+	// proto.Message
+	res := &structpb.Struct{}
+	assert.Equal(t, nil, codeTool.Decode(data, res))
+	assert.True(t, proto.Equal(msg, res))
+
+	// 指向 proto.Message 的指针
+	var res2 *structpb.Struct
+	assert.Equal(t, nil, codeTool.Decode(data, &res2))
+	assert.True(t, proto.Equal(msg, res2))
+
+	// 不是 proto.Message
+	_, err = codeTool.Encode(userInfo{})
+	assert.NotNil(t, err)
+	var s string
+	assert.NotNil(t, codeTool.Decode(data, &s))
+	assert.NotNil(t, codeTool.Decode([]byte{0xff}, &structpb.Struct{}))
+}
+
+// 复用的缓冲区在并发使用时结果正确
+func TestConcurrent(t *testing.T) {
+	var wg sync.WaitGroup
+	for _, codeTool := range []Codec{GobCodec{}, JsonCodec{}, MsgPackCodec{}} {
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func(codeTool Codec, i int) {
+				defer wg.Done()
+				for n := 0; n < 50; n++ {
+					users := newUsers(i*10 + n)
+					data, err := codeTool.Encode(users)
+					assert.Equal(t, nil, err)
+					var res []userInfo
+					assert.Equal(t, nil, codeTool.Decode(data, &res))
+					assert.Equal(t, len(users), len(res))
+					if len(users) > 0 {
+						assert.Equal(t, users[len(users)-1], res[len(res)-1])
+					}
+				}
+			}(codeTool, i)
+		}
+	}
+	wg.Wait()
+}
+
+func newUsers(n int) []userInfo {
+	users := make([]userInfo, n)
+	for i := range users {
+		users[i] = userInfo{Name: fmt.Sprintf("user-%d", i), Id: uint64(i), Tags: []string{"vip", "beijing"}, Ext: map[string]string{"k": "v"}}
+	}
+	return users
+}
+
+// benchmarkCodec 编码和解码 100 个用户信息（典型的列表查询结果）
+func benchmarkCodec(b *testing.B, codeTool Codec) {
+	users := newUsers(100)
+	data, err := codeTool.Encode(users)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Run("encode", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			codeTool.Encode(users)
+		}
+	})
+	b.Run("decode", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var res []userInfo
+			codeTool.Decode(data, &res)
+		}
+	})
+}
+
+func BenchmarkGob(b *testing.B) {
+	benchmarkCodec(b, GobCodec{})
+}
+
+func BenchmarkJson(b *testing.B) {
+	benchmarkCodec(b, JsonCodec{})
+}
+
+func BenchmarkMsgPack(b *testing.B) {
+	benchmarkCodec(b, MsgPackCodec{})
+}
+
+func BenchmarkProtobuf(b *testing.B) {
+	values := make([]*structpb.Value, 100)
+	for i := range values {
+		values[i] = structpb.NewStringValue(fmt.Sprintf("user-%d", i))
+	}
+	msg := &structpb.ListValue{Values: values}
+	codeTool := ProtobufCodec{}
+	data, err := codeTool.Encode(msg)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Run("encode", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			codeTool.Encode(msg)
+		}
+	})
+	b.Run("decode", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var res *structpb.ListValue
+			codeTool.Decode(data, &res)
+		}
+	})
+}
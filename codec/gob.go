@@ -1,29 +1,34 @@
 package codec
 
 import (
-	"bytes"
 	"encoding/gob"
+
+	"github.com/gofish2020/easyrpc/utils"
 )
 
+// GobCodec 每次编码都需要新的 gob.Encoder（每个数据包需要包含完整的类型信息，接收方才能单独解码），
+// 只复用缓冲区
 type GobCodec struct {
 }
 
 func (t GobCodec) Encode(i interface{}) ([]byte, error) {
-	// buffer 空内存流
-	var buffer bytes.Buffer
-	encoder := gob.NewEncoder(&buffer)
+	// buffer 池中的内存流
+	buffer := utils.GetBuffer()
+	defer utils.PutBuffer(buffer)
+	encoder := gob.NewEncoder(buffer)
 	// 编码后的结果保存到buffer中
 	if err := encoder.Encode(i); err != nil {
 		return nil, err
 	}
-	// 返回内存流中的数据
-	return buffer.Bytes(), nil
+	// buffer 会被复用，返回数据的副本
+	return utils.CopyBytes(buffer.Bytes()), nil
 }
 
 func (t GobCodec) Decode(data []byte, i interface{}) error {
-	// buffer 内存流（初始内容为data）
-	buffer := bytes.NewBuffer(data)
-	decoder := gob.NewDecoder(buffer)
-	// 解码buffer中的数据
+	// reader 读取data
+	reader := utils.GetReader(data)
+	defer utils.PutReader(reader)
+	decoder := gob.NewDecoder(reader)
+	// 解码reader中的数据
 	return decoder.Decode(i)
 }
@@ -1,8 +1,9 @@
 package codec
 
 import (
-	"bytes"
 	"encoding/json"
+
+	"github.com/gofish2020/easyrpc/utils"
 )
 
 /*
@@ -16,7 +17,9 @@ func (t JsonCodec) Encode(i interface{}) ([]byte, error) {
 }
 
 func (t JsonCodec) Decode(data []byte, i interface{}) error {
-	decode := json.NewDecoder(bytes.NewBuffer(data))
+	reader := utils.GetReader(data)
+	defer utils.PutReader(reader)
+	decode := json.NewDecoder(reader)
 	decode.UseNumber()
 	return decode.Decode(i)
 	//return json.Unmarshal(data, i)
@@ -0,0 +1,30 @@
+package codec
+
+import (
+	"github.com/gofish2020/easyrpc/utils"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+/*
+purpose: MessagePack序列化和反序列化，结构体字段可以使用 msgpack 标签
+msgpack.Encoder/Decoder 由 msgpack 包复用，这里复用编码的缓冲区
+*/
+type MsgPackCodec struct {
+}
+
+func (t MsgPackCodec) Encode(i interface{}) ([]byte, error) {
+	buffer := utils.GetBuffer()
+	defer utils.PutBuffer(buffer)
+
+	enc := msgpack.GetEncoder()
+	defer msgpack.PutEncoder(enc)
+	enc.Reset(buffer)
+	if err := enc.Encode(i); err != nil {
+		return nil, err
+	}
+	return utils.CopyBytes(buffer.Bytes()), nil
+}
+
+func (t MsgPackCodec) Decode(data []byte, i interface{}) error {
+	return msgpack.Unmarshal(data, i)
+}
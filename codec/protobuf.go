@@ -1 +1,44 @@
 package codec
+
+import (
+	"fmt"
+	"reflect"
+
+	"google.golang.org/protobuf/proto"
+)
+
+/*
+purpose: protobuf序列化和反序列化，参数必须是 proto.Message（protoc 生成的消息指针）
+注意：空消息编码后长度为0，接收方得到的是nil指针（protobuf生成的Get方法可以处理nil）
+*/
+type ProtobufCodec struct {
+}
+
+func (t ProtobufCodec) Encode(i interface{}) ([]byte, error) {
+	m, ok := i.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("protobuf codec: %T is not proto.Message", i)
+	}
+	return proto.Marshal(m)
+}
+
+// Decode i 可以是 proto.Message，或者指向 proto.Message 的指针（按照参数类型解码时）
+func (t ProtobufCodec) Decode(data []byte, i interface{}) error {
+	if m, ok := i.(proto.Message); ok {
+		return proto.Unmarshal(data, m)
+	}
+	v := reflect.ValueOf(i)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Ptr {
+		return fmt.Errorf("protobuf codec: %T is not proto.Message", i)
+	}
+	elem := reflect.New(v.Elem().Type().Elem())
+	m, ok := elem.Interface().(proto.Message)
+	if !ok {
+		return fmt.Errorf("protobuf codec: %T is not proto.Message", i)
+	}
+	if err := proto.Unmarshal(data, m); err != nil {
+		return err
+	}
+	v.Elem().Set(elem)
+	return nil
+}
@@ -0,0 +1,132 @@
+package compress
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type userInfo struct {
+	Name  string
+	Id    uint64
+	Email string
+	Tags  []string
+}
+
+func newUsers(n int) []userInfo {
+	users := make([]userInfo, n)
+	for i := range users {
+		users[i] = userInfo{
+			Name:  fmt.Sprintf("user-%d", i),
+			Id:    uint64(i),
+			Email: fmt.Sprintf("user-%d@example.com", i),
+			Tags:  []string{"vip", "beijing", fmt.Sprintf("group-%d", i%10)},
+		}
+	}
+	return users
+}
+
+// payloads 有代表性的RPC数据：小请求、json/gob编码的列表、不可压缩的随机数据
+func payloads() []struct {
+	name string
+	data []byte
+} {
+	small, _ := json.Marshal(userInfo{Name: "nash", Id: 1})
+	jsonData, _ := json.Marshal(newUsers(100))
+	var gobData bytes.Buffer
+	gob.NewEncoder(&gobData).Encode(newUsers(100))
+	random := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	return []struct {
+		name string
+		data []byte
+	}{
+		{"small", small},
+		{"json", jsonData},
+		{"gob", gobData.Bytes()},
+		{"random", random},
+	}
+}
+
+// testLevels 各个压缩级别都可以正确解压缩
+func testLevels(t *testing.T, newCompressor func(opts ...Option) Compression, levels ...int) {
+	for _, level := range levels {
+		c := newCompressor(WithLevel(level))
+		for _, p := range payloads() {
+			compressRes, err := c.Compress(p.data)
+			assert.Equal(t, nil, err, "level %d", level)
+			t.Logf("level %d %s: %d -> %d", level, p.name, len(p.data), len(compressRes))
+
+			dataNew, err := c.UnCompress(compressRes)
+			assert.Equal(t, nil, err, "level %d", level)
+			assert.Equal(t, p.data, dataNew)
+		}
+	}
+}
+
+// benchmarkCompression 压缩和解压缩的吞吐量（MB/s 按照原始数据计算），ratio 为压缩后/压缩前
+func benchmarkCompression(b *testing.B, c Compression) {
+	for _, p := range payloads() {
+		compressRes, err := c.Compress(p.data)
+		if err != nil {
+			b.Fatal(err)
+		}
+		ratio := float64(len(compressRes)) / float64(len(p.data))
+
+		b.Run(p.name+"/compress", func(b *testing.B) {
+			b.SetBytes(int64(len(p.data)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				c.Compress(p.data)
+			}
+			b.ReportMetric(ratio, "ratio")
+		})
+		b.Run(p.name+"/uncompress", func(b *testing.B) {
+			b.SetBytes(int64(len(p.data)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				c.UnCompress(compressRes)
+			}
+		})
+	}
+}
+
+// 复用的 Writer/Reader/缓冲区在并发使用时结果正确
+func TestConcurrent(t *testing.T) {
+	compressors := []Compression{
+		GetNoneCompresser(),
+		GetZlibCompresser(),
+		GetZlibCompresser(WithLevel(9)),
+		GetSnappyCompresser(),
+		GetLz4Compresser(),
+		GetLz4Compresser(WithLevel(0)),
+		GetZstdCompresser(),
+		GetGzipCompresser(),
+	}
+	var wg sync.WaitGroup
+	for _, c := range compressors {
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func(c Compression) {
+				defer wg.Done()
+				for n := 0; n < 5; n++ {
+					for _, p := range payloads() {
+						compressRes, err := c.Compress(p.data)
+						assert.Equal(t, nil, err)
+						dataNew, err := c.UnCompress(compressRes)
+						assert.Equal(t, nil, err)
+						assert.Equal(t, p.data, dataNew)
+					}
+				}
+			}(c)
+		}
+	}
+	wg.Wait()
+}
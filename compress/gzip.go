@@ -0,0 +1,79 @@
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/gofish2020/easyrpc/utils"
+)
+
+// Gzip 压缩级别 -2(HuffmanOnly) ~ 9(BestCompression)，默认 -1(DefaultCompression)
+type Gzip struct {
+	options
+}
+
+// 复用 gzip.Writer（按压缩级别）和 gzip.Reader
+var (
+	gzipWriterPools [gzip.BestCompression - gzip.HuffmanOnly + 1]sync.Pool
+	gzipReaderPool  sync.Pool
+)
+
+func GetGzipCompresser(opts ...Option) Gzip {
+	return Gzip{options: newOptions(opts)}
+}
+
+func (t Gzip) Compress(data []byte) ([]byte, error) {
+	level := t.levelOr(gzip.DefaultCompression)
+	if level < gzip.HuffmanOnly || level > gzip.BestCompression {
+		return nil, fmt.Errorf("gzip: invalid compression level: %d", level)
+	}
+	in := utils.GetBuffer()
+	defer utils.PutBuffer(in)
+
+	pool := &gzipWriterPools[level-gzip.HuffmanOnly]
+	w, ok := pool.Get().(*gzip.Writer)
+	if ok {
+		w.Reset(in)
+	} else {
+		w, _ = gzip.NewWriterLevel(in, level) // 级别已经检查过
+	}
+	defer pool.Put(w)
+
+	_, err := w.Write(data)
+	if err != nil {
+		return nil, err
+	}
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+	return utils.CopyBytes(in.Bytes()), nil
+}
+
+func (t Gzip) UnCompress(data []byte) ([]byte, error) {
+	in := bytes.NewReader(data)
+	r, ok := gzipReaderPool.Get().(*gzip.Reader)
+	if !ok {
+		r = new(gzip.Reader)
+	}
+	defer gzipReaderPool.Put(r)
+	if err := r.Reset(in); err != nil {
+		return nil, err
+	}
+
+	out := utils.GetBuffer()
+	defer utils.PutBuffer(out)
+	_, err := io.Copy(out, r)
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.Close()
+	if err != nil {
+		return nil, err
+	}
+	return utils.CopyBytes(out.Bytes()), nil
+}
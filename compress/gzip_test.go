@@ -0,0 +1,36 @@
+package compress
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGzip(t *testing.T) {
+	data := []byte(`{"name":"nash","id":1,"tags":["vip","beijing"],"email":"nash@example.com"}`)
+
+	gzip := GetGzipCompresser()
+
+	compressRes, err := gzip.Compress(data)
+	assert.Equal(t, err, nil)
+
+	dataNew, err := gzip.UnCompress(compressRes)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, data, dataNew)
+
+	// 级别超出范围
+	_, err = GetGzipCompresser(WithLevel(10)).Compress(data)
+	assert.NotNil(t, err)
+}
+
+func TestGzipLevels(t *testing.T) {
+	testLevels(t, func(opts ...Option) Compression { return GetGzipCompresser(opts...) }, -2, 0, 1, 9)
+}
+
+func BenchmarkGzip(b *testing.B) {
+	benchmarkCompression(b, GetGzipCompresser())
+}
+
+func BenchmarkGzipBestSpeed(b *testing.B) {
+	benchmarkCompression(b, GetGzipCompresser(WithLevel(1)))
+}
@@ -2,22 +2,51 @@ package compress
 
 import (
 	"bytes"
+	"fmt"
 	"io"
+	"sync"
 
+	"github.com/gofish2020/easyrpc/utils"
 	"github.com/pierrec/lz4/v4"
 )
 
+// Lz4 压缩级别 0(Fast) 1 ~ 9，默认 1
 type Lz4 struct {
+	options
 }
 
+var lz4Levels = [...]lz4.CompressionLevel{lz4.Fast, lz4.Level1, lz4.Level2, lz4.Level3, lz4.Level4, lz4.Level5, lz4.Level6, lz4.Level7, lz4.Level8, lz4.Level9}
+
+// 复用 lz4.Writer（按压缩级别，Reset 后保留级别）和 lz4.Reader
+var (
+	lz4WriterPools [len(lz4Levels)]sync.Pool
+	lz4ReaderPool  = sync.Pool{
+		New: func() interface{} {
+			return lz4.NewReader(nil)
+		},
+	}
+)
+
 func (t Lz4) Compress(data []byte) ([]byte, error) {
+	level := t.levelOr(1)
+	if level < 0 || level >= len(lz4Levels) {
+		return nil, fmt.Errorf("lz4: invalid compression level %d", level)
+	}
 
-	zout := bytes.NewBuffer([]byte{})
+	zout := utils.GetBuffer()
+	defer utils.PutBuffer(zout)
 
-	zw := lz4.NewWriter(zout)
-	if err := zw.Apply([]lz4.Option{lz4.CompressionLevelOption(lz4.Level1)}...); err != nil {
-		return nil, err
+	pool := &lz4WriterPools[level]
+	zw, ok := pool.Get().(*lz4.Writer)
+	if ok {
+		zw.Reset(zout)
+	} else {
+		zw = lz4.NewWriter(zout)
+		if err := zw.Apply([]lz4.Option{lz4.CompressionLevelOption(lz4Levels[level])}...); err != nil {
+			return nil, err
+		}
 	}
+	defer pool.Put(zw)
 
 	_, err := io.Copy(zw, bytes.NewReader(data))
 	if err != nil {
@@ -27,23 +56,29 @@ func (t Lz4) Compress(data []byte) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	return zout.Bytes(), nil
+	return utils.CopyBytes(zout.Bytes()), nil
 }
 
 func (t Lz4) UnCompress(data []byte) ([]byte, error) {
 
-	zin := bytes.NewReader(data)
-	zr := lz4.NewReader(zin)
-	zout := new(bytes.Buffer)
+	zr := lz4ReaderPool.Get().(*lz4.Reader)
+	zr.Reset(bytes.NewReader(data))
+	defer func() {
+		zr.Reset(nil) // 归还数据块缓冲区
+		lz4ReaderPool.Put(zr)
+	}()
+
+	zout := utils.GetBuffer()
+	defer utils.PutBuffer(zout)
 
 	_, err := io.Copy(zout, zr)
 	if err != nil {
 		return nil, err
 	}
 
-	return zout.Bytes(), nil
+	return utils.CopyBytes(zout.Bytes()), nil
 }
 
-func GetLz4Compresser() Lz4 {
-	return Lz4{}
+func GetLz4Compresser(opts ...Option) Lz4 {
+	return Lz4{options: newOptions(opts)}
 }
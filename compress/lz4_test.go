@@ -35,3 +35,20 @@ func TestLz4(t *testing.T) {
 
 	assert.Equal(t, []byte(data), dataNew)
 }
+
+func TestLz4Levels(t *testing.T) {
+	testLevels(t, func(opts ...Option) Compression { return GetLz4Compresser(opts...) }, 0, 1, 9)
+}
+
+func BenchmarkLz4(b *testing.B) {
+	benchmarkCompression(b, GetLz4Compresser())
+}
+
+func BenchmarkLz4Level9(b *testing.B) {
+	benchmarkCompression(b, GetLz4Compresser(WithLevel(9)))
+}
+
+func TestLz4InvalidLevel(t *testing.T) {
+	_, err := GetLz4Compresser(WithLevel(10)).Compress([]byte("data"))
+	assert.NotNil(t, err)
+}
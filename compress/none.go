@@ -0,0 +1,17 @@
+package compress
+
+// None 不压缩，原样返回
+type None struct {
+}
+
+func GetNoneCompresser() None {
+	return None{}
+}
+
+func (t None) Compress(data []byte) ([]byte, error) {
+	return data, nil
+}
+
+func (t None) UnCompress(data []byte) ([]byte, error) {
+	return data, nil
+}
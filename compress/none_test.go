@@ -0,0 +1,21 @@
+package compress
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNone(t *testing.T) {
+	data := []byte("func CloneObject(a, b interface{}) []byte")
+
+	none := GetNoneCompresser()
+
+	compressRes, err := none.Compress(data)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, data, compressRes)
+
+	dataNew, err := none.UnCompress(compressRes)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, data, dataNew)
+}
@@ -0,0 +1,43 @@
+package compress
+
+// Option 创建压缩器时的配置
+type Option func(*options)
+
+type options struct {
+	level    int
+	levelSet bool
+	dict     []byte
+}
+
+// WithLevel 压缩级别，取值范围由具体的算法决定（见各压缩器的说明），没有设置时使用算法的默认级别
+func WithLevel(level int) Option {
+	return func(o *options) {
+		o.level = level
+		o.levelSet = true
+	}
+}
+
+// WithDictionary 共享字典（仅 zstd 有效），压缩和解压缩需要使用相同的字典
+// 在 rpc 中使用时，需要通过 rpcmsg.RegisterCompressor 在服务端和客户端注册为自定义类型
+// 字典可以通过 zstd --train 或者 github.com/klauspost/compress/dict 生成
+func WithDictionary(dict []byte) Option {
+	return func(o *options) {
+		o.dict = dict
+	}
+}
+
+func newOptions(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// levelOr 没有设置压缩级别时返回 def
+func (o options) levelOr(def int) int {
+	if o.levelSet {
+		return o.level
+	}
+	return def
+}
@@ -1,16 +1,28 @@
 package compress
 
-import "github.com/golang/snappy"
+import (
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/s2"
+)
 
+// Snappy 压缩级别 1(默认) 2(Better) 3(Best)，级别越高压缩率越高、速度越慢，输出都是标准的snappy格式
 type Snappy struct {
+	options
 }
 
-func GetSnappyCompresser() Snappy {
-	return Snappy{}
+func GetSnappyCompresser(opts ...Option) Snappy {
+	return Snappy{options: newOptions(opts)}
 }
 
 func (t Snappy) Compress(data []byte) ([]byte, error) {
-	return snappy.Encode(nil, data), nil
+	switch level := t.levelOr(1); {
+	case level <= 1:
+		return snappy.Encode(nil, data), nil
+	case level == 2:
+		return s2.EncodeSnappyBetter(nil, data), nil
+	default:
+		return s2.EncodeSnappyBest(nil, data), nil
+	}
 }
 
 func (t Snappy) UnCompress(data []byte) ([]byte, error) {
@@ -35,3 +35,15 @@ func TestSnappy(t *testing.T) {
 
 	assert.Equal(t, []byte(data), dataNew)
 }
+
+func TestSnappyLevels(t *testing.T) {
+	testLevels(t, func(opts ...Option) Compression { return GetSnappyCompresser(opts...) }, 1, 2, 3)
+}
+
+func BenchmarkSnappy(b *testing.B) {
+	benchmarkCompression(b, GetSnappyCompresser())
+}
+
+func BenchmarkSnappyBest(b *testing.B) {
+	benchmarkCompression(b, GetSnappyCompresser(WithLevel(3)))
+}
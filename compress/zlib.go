@@ -3,23 +3,46 @@ package compress
 import (
 	"bytes"
 	"compress/zlib"
+	"fmt"
 	"io"
+	"sync"
+
+	"github.com/gofish2020/easyrpc/utils"
 )
 
+// Zlib 压缩级别 -2(HuffmanOnly) ~ 9(BestCompression)，默认 -1(DefaultCompression)
 type Zlib struct {
+	options
 }
 
-func GetZlibCompresser() Zlib {
-	return Zlib{}
+// 复用 zlib.Writer（按压缩级别）和 zlib.Reader，创建时分配的内存较多
+var (
+	zlibWriterPools [zlib.BestCompression - zlib.HuffmanOnly + 1]sync.Pool
+	zlibReaderPool  sync.Pool
+)
+
+func GetZlibCompresser(opts ...Option) Zlib {
+	return Zlib{options: newOptions(opts)}
 }
 
 func (t Zlib) Compress(data []byte) ([]byte, error) {
-	var in bytes.Buffer
-	w, err := zlib.NewWriterLevel(&in, zlib.DefaultCompression)
-	if err != nil {
-		return nil, err
+	level := t.levelOr(zlib.DefaultCompression)
+	if level < zlib.HuffmanOnly || level > zlib.BestCompression {
+		return nil, fmt.Errorf("zlib: invalid compression level: %d", level)
+	}
+	in := utils.GetBuffer()
+	defer utils.PutBuffer(in)
+
+	pool := &zlibWriterPools[level-zlib.HuffmanOnly]
+	w, ok := pool.Get().(*zlib.Writer)
+	if ok {
+		w.Reset(in)
+	} else {
+		w, _ = zlib.NewWriterLevel(in, level) // 级别已经检查过
 	}
-	_, err = w.Write(data)
+	defer pool.Put(w)
+
+	_, err := w.Write(data)
 	if err != nil {
 		return nil, err
 	}
@@ -27,27 +50,41 @@ func (t Zlib) Compress(data []byte) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	return in.Bytes(), nil
+	return utils.CopyBytes(in.Bytes()), nil
 }
 
 func (t Zlib) UnCompress(data []byte) ([]byte, error) {
 
-	in := bytes.NewBuffer(data)
-	r, err := zlib.NewReader(in)
+	in := bytes.NewReader(data)
+	var r io.ReadCloser
+	var err error
+	if pooled, ok := zlibReaderPool.Get().(io.ReadCloser); ok {
+		r = pooled
+		err = r.(zlib.Resetter).Reset(in, nil)
+	} else {
+		r, err = zlib.NewReader(in)
+	}
+	if r != nil {
+		defer zlibReaderPool.Put(r)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	out := bytes.NewBuffer([]byte{})
-	io.Copy(out, r)
+	out := utils.GetBuffer()
+	defer utils.PutBuffer(out)
+	_, err = io.Copy(out, r)
+	if err != nil {
+		return nil, err
+	}
 
 	err = r.Close()
 	if err != nil {
 		return nil, err
 	}
-	return out.Bytes(), nil
+	return utils.CopyBytes(out.Bytes()), nil
 }
 
-func NewCompressZlib() Zlib {
-	return Zlib{}
+func NewCompressZlib(opts ...Option) Zlib {
+	return GetZlibCompresser(opts...)
 }
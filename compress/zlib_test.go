@@ -35,3 +35,15 @@ func TestZlib(t *testing.T) {
 
 	assert.Equal(t, []byte(data), dataNew)
 }
+
+func TestZlibLevels(t *testing.T) {
+	testLevels(t, func(opts ...Option) Compression { return GetZlibCompresser(opts...) }, -2, 0, 1, 9)
+}
+
+func BenchmarkZlib(b *testing.B) {
+	benchmarkCompression(b, GetZlibCompresser())
+}
+
+func BenchmarkZlibBestSpeed(b *testing.B) {
+	benchmarkCompression(b, GetZlibCompresser(WithLevel(1)))
+}
@@ -0,0 +1,54 @@
+package compress
+
+import (
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Zstd 压缩级别和 zstd 命令行一致 1 ~ 22，默认 3（映射为 Fastest/Default/Better/Best 四个级别）
+// 可以通过 WithDictionary 设置共享字典，小数据包的压缩率更高
+type Zstd struct {
+	options
+
+	once    sync.Once
+	encoder *zstd.Encoder // EncodeAll/DecodeAll 可以并发调用
+	decoder *zstd.Decoder
+	err     error
+}
+
+func GetZstdCompresser(opts ...Option) *Zstd {
+	return &Zstd{options: newOptions(opts)}
+}
+
+// init 第一次使用时创建编码器和解码器（字典格式错误时返回错误）
+func (t *Zstd) init() error {
+	t.once.Do(func() {
+		eOpts := []zstd.EOption{zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(t.levelOr(3)))}
+		var dOpts []zstd.DOption
+		if t.dict != nil {
+			eOpts = append(eOpts, zstd.WithEncoderDict(t.dict))
+			dOpts = append(dOpts, zstd.WithDecoderDicts(t.dict))
+		}
+		t.encoder, t.err = zstd.NewWriter(nil, eOpts...)
+		if t.err != nil {
+			return
+		}
+		t.decoder, t.err = zstd.NewReader(nil, dOpts...)
+	})
+	return t.err
+}
+
+func (t *Zstd) Compress(data []byte) ([]byte, error) {
+	if err := t.init(); err != nil {
+		return nil, err
+	}
+	return t.encoder.EncodeAll(data, nil), nil
+}
+
+func (t *Zstd) UnCompress(data []byte) ([]byte, error) {
+	if err := t.init(); err != nil {
+		return nil, err
+	}
+	return t.decoder.DecodeAll(data, nil)
+}
@@ -0,0 +1,80 @@
+package compress
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/klauspost/compress/dict"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestZstd(t *testing.T) {
+	data := []byte(`{"name":"nash","id":1,"tags":["vip","beijing"],"email":"nash@example.com"}`)
+
+	zstd := GetZstdCompresser()
+
+	compressRes, err := zstd.Compress(data)
+	assert.Equal(t, err, nil)
+
+	dataNew, err := zstd.UnCompress(compressRes)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, data, dataNew)
+
+	_, err = zstd.UnCompress([]byte("not zstd"))
+	assert.NotNil(t, err)
+}
+
+func TestZstdLevels(t *testing.T) {
+	testLevels(t, func(opts ...Option) Compression { return GetZstdCompresser(opts...) }, 1, 3, 7, 19)
+}
+
+// newDictionary 用相似的小数据包训练字典
+func newDictionary(t testing.TB) []byte {
+	samples := make([][]byte, 0, 200)
+	for _, user := range newUsers(200) {
+		sample, _ := json.Marshal(user)
+		samples = append(samples, sample)
+	}
+	d, err := dict.BuildZstdDict(samples, dict.Options{MaxDictSize: 4096, HashBytes: 6, ZstdDictID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestZstdDictionary(t *testing.T) {
+	data, _ := json.Marshal(userInfo{Name: "user-2048", Id: 2048, Email: "user-2048@example.com", Tags: []string{"vip", "beijing", "group-8"}})
+	d := newDictionary(t)
+
+	withDict := GetZstdCompresser(WithDictionary(d))
+	compressRes, err := withDict.Compress(data)
+	assert.Equal(t, nil, err)
+	dataNew, err := withDict.UnCompress(compressRes)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, data, dataNew)
+
+	// 小数据包使用字典后压缩率更高
+	noDict, _ := GetZstdCompresser().Compress(data)
+	t.Log(len(data), len(noDict), len(compressRes))
+	assert.Less(t, len(compressRes), len(noDict))
+
+	// 没有字典无法解压缩
+	_, err = GetZstdCompresser().UnCompress(compressRes)
+	assert.NotNil(t, err)
+
+	// 字典格式错误
+	_, err = GetZstdCompresser(WithDictionary([]byte("bad dictionary"))).Compress(data)
+	assert.NotNil(t, err)
+}
+
+func BenchmarkZstd(b *testing.B) {
+	benchmarkCompression(b, GetZstdCompresser())
+}
+
+func BenchmarkZstdFastest(b *testing.B) {
+	benchmarkCompression(b, GetZstdCompresser(WithLevel(1)))
+}
+
+func BenchmarkZstdDictionary(b *testing.B) {
+	benchmarkCompression(b, GetZstdCompresser(WithDictionary(newDictionary(b))))
+}
@@ -2,7 +2,7 @@ package main
 
 import (
 	"context"
-	"encoding/gob"
+	"errors"
 	"fmt"
 	"log"
 	"time"
@@ -12,7 +12,6 @@ import (
 )
 
 func main() {
-	gob.RegisterName("info", user.Info{})
 	option := rpcclient.DefaultOption
 	client := rpcclient.NewRPCClient(option)
 
@@ -51,6 +50,22 @@ func main() {
 	info, err := GetUserInfoById(1)
 	fmt.Println(info, err)
 
+	// 服务端返回的业务错误
+	_, err = GetUserInfoById(100)
+	fmt.Println(err, errors.Is(err, user.ErrUserNotExist))
+
+	// 第一个参数为 context.Context 的存根，每次调用可以设置超时时间
+	var GetUserIds func(ctx context.Context) ([]int, error)
+	_, err = client.Call(context.Background(), "User.GetUserIds", &GetUserIds)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	ids, err := GetUserIds(ctx)
+	cancel()
+	fmt.Println(ids, err)
+
 	<-time.After(8 * time.Second)
 
 }
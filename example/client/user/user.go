@@ -1,5 +1,20 @@
 package user
 
+import (
+	"errors"
+
+	"github.com/gofish2020/easyrpc/rpcmsg"
+)
+
+// 业务错误码（和服务端保持一致）
+const CodeUserNotExist = rpcmsg.CodeUserBase + 1
+
+var ErrUserNotExist = errors.New("user not exist")
+
+func init() {
+	rpcmsg.RegisterError(CodeUserNotExist, ErrUserNotExist)
+}
+
 type Info struct {
 	Name string `json:"name"`
 	Id   uint64 `json:"id"`
@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"encoding/gob"
 	"log"
 	"os"
 	"os/signal"
@@ -14,16 +13,20 @@ import (
 )
 
 func main() {
-	gob.RegisterName("info", user.Info{})
 	option := rpcserver.Option{
 		Ip:           "127.0.0.1",
 		Port:         6060,
 		ReadTimeout:  3 * time.Second,
 		WriteTimeout: 3 * time.Second,
+
+		MaxConcurrentPerConn: 64,
+		MaxConcurrent:        1024,
 	}
 
 	server := rpcserver.NewRPCServer(option)
-	server.RegisterByName("User", &user.UserService{})
+	if err := server.RegisterByName("User", &user.UserService{}); err != nil {
+		log.Fatal(err)
+	}
 	server.Run()
 
 	quit := make(chan os.Signal, 1)
@@ -34,12 +37,8 @@ func main() {
 	defer cancel()
 	log.Printf("start shutdown server")
 	// 优雅关闭服务
-	server.Shutdown()
-
-	select {
-	case <-ctx.Done():
-		log.Println("server close timeout")
-	default:
+	if err := server.Shutdown(ctx); err != nil {
+		log.Println(err)
 	}
 
 	log.Println("server exiting")
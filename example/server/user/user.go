@@ -1,6 +1,21 @@
 package user
 
-import "fmt"
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/gofish2020/easyrpc/rpcmsg"
+)
+
+// 业务错误码（客户端注册相同的错误码）
+const CodeUserNotExist = rpcmsg.CodeUserBase + 1
+
+var ErrUserNotExist = errors.New("user not exist")
+
+func init() {
+	rpcmsg.RegisterError(CodeUserNotExist, ErrUserNotExist)
+}
 
 type Info struct {
 	Name string `json:"name"`
@@ -30,7 +45,11 @@ func (t *UserService) SayHello(s string) (string, error) {
 	return s, nil
 }
 
-func (t *UserService) GetUserIds() ([]int, error) {
+// GetUserIds 第一个参数是 context.Context，由框架传入（客户端不需要发送）
+func (t *UserService) GetUserIds(ctx context.Context) ([]int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	return []int{1, 2, 3}, nil
 }
 
@@ -39,5 +58,5 @@ func (t *UserService) GetUserInfoById(id int) (Info, error) {
 	if info, ok := db[id]; ok {
 		return info, nil
 	}
-	return Info{}, fmt.Errorf("user not exist")
+	return Info{}, fmt.Errorf("id %d: %w", id, ErrUserNotExist)
 }
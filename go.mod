@@ -4,8 +4,10 @@ go 1.19
 
 require (
 	github.com/golang/snappy v0.0.4
+	github.com/klauspost/compress v1.17.4
 	github.com/pierrec/lz4/v4 v4.1.18
-
+	github.com/vmihailenco/msgpack/v5 v5.4.1
+	google.golang.org/protobuf v1.33.0
 )
 
 require (
@@ -16,5 +18,6 @@ require (
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
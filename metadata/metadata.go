@@ -0,0 +1,113 @@
+package metadata
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// MD 请求/响应附带的键值对，例如链路追踪id、鉴权信息
+type MD map[string]string
+
+// Pairs 按照 key, value, key, value... 创建 MD
+func Pairs(kv ...string) MD {
+	if len(kv)%2 == 1 {
+		panic(fmt.Sprintf("metadata: Pairs got an odd number of arguments: %d", len(kv)))
+	}
+	md := make(MD, len(kv)/2)
+	for i := 0; i < len(kv); i += 2 {
+		md[kv[i]] = kv[i+1]
+	}
+	return md
+}
+
+func (md MD) Get(key string) string {
+	return md[key]
+}
+
+func (md MD) Set(key, value string) {
+	md[key] = value
+}
+
+func (md MD) Copy() MD {
+	res := make(MD, len(md))
+	for k, v := range md {
+		res[k] = v
+	}
+	return res
+}
+
+// Join 合并多个 MD，相同的key后面的覆盖前面的
+func Join(mds ...MD) MD {
+	res := MD{}
+	for _, md := range mds {
+		for k, v := range md {
+			res[k] = v
+		}
+	}
+	return res
+}
+
+type outgoingKey struct{}
+type incomingKey struct{}
+type trailerKey struct{}
+
+// NewOutgoingContext 客户端：设置随请求发送的 metadata（替换ctx中已有的）
+func NewOutgoingContext(ctx context.Context, md MD) context.Context {
+	return context.WithValue(ctx, outgoingKey{}, md)
+}
+
+// AppendToOutgoingContext 客户端：在ctx已有的 metadata 上增加键值对
+func AppendToOutgoingContext(ctx context.Context, kv ...string) context.Context {
+	md, _ := FromOutgoingContext(ctx)
+	return NewOutgoingContext(ctx, Join(md, Pairs(kv...)))
+}
+
+// FromOutgoingContext 客户端：获取随请求发送的 metadata
+func FromOutgoingContext(ctx context.Context) (MD, bool) {
+	md, ok := ctx.Value(outgoingKey{}).(MD)
+	return md, ok
+}
+
+// NewIncomingContext 服务端：框架保存客户端发送的 metadata
+func NewIncomingContext(ctx context.Context, md MD) context.Context {
+	return context.WithValue(ctx, incomingKey{}, md)
+}
+
+// FromIncomingContext 服务端：获取客户端发送的 metadata
+func FromIncomingContext(ctx context.Context) (MD, bool) {
+	md, ok := ctx.Value(incomingKey{}).(MD)
+	return md, ok
+}
+
+type trailer struct {
+	mu sync.Mutex
+	md *MD
+}
+
+// WithTrailer 在ctx中保存响应的 trailer：
+// 客户端请求完成后 *md 为服务端返回的 trailer；服务端由框架调用，收集 SetTrailer 设置的值
+func WithTrailer(ctx context.Context, md *MD) context.Context {
+	return context.WithValue(ctx, trailerKey{}, &trailer{md: md})
+}
+
+// SetTrailer 服务端：设置随响应返回的键值对，可以多次调用
+func SetTrailer(ctx context.Context, md MD) error {
+	t, ok := ctx.Value(trailerKey{}).(*trailer)
+	if !ok {
+		return fmt.Errorf("metadata: no trailer in context")
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	*t.md = Join(*t.md, md)
+	return nil
+}
+
+// SetReceivedTrailer 客户端：框架保存服务端返回的 trailer
+func SetReceivedTrailer(ctx context.Context, md MD) {
+	if t, ok := ctx.Value(trailerKey{}).(*trailer); ok {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		*t.md = md
+	}
+}
@@ -0,0 +1,39 @@
+package metadata
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutgoingContext(t *testing.T) {
+	ctx := context.Background()
+	_, ok := FromOutgoingContext(ctx)
+	assert.False(t, ok)
+
+	md := Pairs("a", "1")
+	ctx = NewOutgoingContext(ctx, md)
+	ctx2 := AppendToOutgoingContext(ctx, "a", "2", "b", "3")
+
+	// 追加不会修改原来的 metadata
+	md1, _ := FromOutgoingContext(ctx)
+	md2, _ := FromOutgoingContext(ctx2)
+	assert.Equal(t, MD{"a": "1"}, md1)
+	assert.Equal(t, MD{"a": "2", "b": "3"}, md2)
+
+	assert.Panics(t, func() { Pairs("a") })
+}
+
+func TestTrailer(t *testing.T) {
+	assert.NotNil(t, SetTrailer(context.Background(), Pairs("a", "1")))
+
+	var trailer MD
+	ctx := WithTrailer(context.Background(), &trailer)
+	assert.Equal(t, nil, SetTrailer(ctx, Pairs("a", "1")))
+	assert.Equal(t, nil, SetTrailer(ctx, Pairs("b", "2")))
+	assert.Equal(t, MD{"a": "1", "b": "2"}, trailer)
+
+	SetReceivedTrailer(ctx, Pairs("c", "3"))
+	assert.Equal(t, MD{"c": "3"}, trailer)
+}
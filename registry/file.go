@@ -0,0 +1,288 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// FileRegistry 服务实例以JSON数组保存在文件中，定时检查文件的变化和实例是否过期
+// 文件可以由多个进程共享，也可以由配置管理工具生成；多个进程的写入通过锁文件（path.lock）串行执行
+type FileRegistry struct {
+	path     string
+	interval time.Duration
+
+	mu        sync.Mutex
+	raw       []fileInstance        // 最近一次读取的文件内容
+	instances map[string][]Instance // 没有过期的实例
+	modTime   time.Time
+	size      int64
+	watchers  watchers
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+const (
+	lockTimeout  = 5 * time.Second  // 等待锁文件的最长时间
+	staleLockAge = 10 * time.Second // 超过这个时间的锁文件认为持有的进程已经异常退出
+)
+
+// fileInstance 文件中的实例，设置了 TTL 时记录过期时间
+type fileInstance struct {
+	Instance
+	ExpireAt int64 `json:"expire_at,omitempty"` // 过期时间（毫秒时间戳）
+}
+
+func (ins fileInstance) expired(now time.Time) bool {
+	return ins.ExpireAt != 0 && now.UnixMilli() >= ins.ExpireAt
+}
+
+// NewFileRegistry interval 是检查文件变化的间隔，默认1s；文件不存在时没有任何实例
+func NewFileRegistry(path string, interval time.Duration) (*FileRegistry, error) {
+	if interval <= 0 {
+		interval = time.Second
+	}
+	r := &FileRegistry{
+		path:      path,
+		interval:  interval,
+		instances: make(map[string][]Instance),
+		done:      make(chan struct{}),
+	}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	go r.loopWatch()
+	return r, nil
+}
+
+// Close 停止检查文件的变化
+func (r *FileRegistry) Close() {
+	r.closeOnce.Do(func() {
+		close(r.done)
+	})
+}
+
+func (r *FileRegistry) Register(ins Instance) error {
+	if ins.Service == "" || ins.Addr == "" {
+		return fmt.Errorf("service and addr are required")
+	}
+	fi := fileInstance{Instance: ins}
+	if ins.TTL > 0 {
+		fi.ExpireAt = time.Now().Add(ins.TTL).UnixMilli()
+	}
+	return r.modify(func(all []fileInstance) []fileInstance {
+		for i := range all {
+			if all[i].Service == ins.Service && all[i].Addr == ins.Addr {
+				all[i] = fi
+				return all
+			}
+		}
+		return append(all, fi)
+	})
+}
+
+func (r *FileRegistry) Deregister(ins Instance) error {
+	return r.modify(func(all []fileInstance) []fileInstance {
+		res := all[:0]
+		for _, i := range all {
+			if i.Service != ins.Service || i.Addr != ins.Addr {
+				res = append(res, i)
+			}
+		}
+		return res
+	})
+}
+
+func (r *FileRegistry) List(service string) ([]Instance, error) {
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Instance{}, r.instances[service]...), nil
+}
+
+func (r *FileRegistry) Watch(ctx context.Context, service string) (<-chan []Instance, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.watchers.add(ctx, service, append([]Instance{}, r.instances[service]...)), nil
+}
+
+func (r *FileRegistry) loopWatch() {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := r.reload(); err != nil {
+				log.Printf("reload registry file %s error:%+v\n", r.path, err)
+			}
+		case <-r.done:
+			return
+		}
+	}
+}
+
+// reload 文件有变化时重新读取，并移除过期的实例
+func (r *FileRegistry) reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	info, err := os.Stat(r.path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if info != nil && info.ModTime().Equal(r.modTime) && info.Size() == r.size {
+		r.apply(r.raw, info)
+		return nil
+	}
+	all, err := r.readFile()
+	if err != nil {
+		return err
+	}
+	r.apply(all, info)
+	return nil
+}
+
+// modify 读取文件的最新内容（去掉过期的实例），修改后写回文件
+func (r *FileRegistry) modify(fn func([]fileInstance) []fileInstance) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// 读取、修改、写回期间持有锁文件，其他进程的写入不会被覆盖
+	unlock, err := r.lockFile()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	all, err := r.readFile()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	alive := make([]fileInstance, 0, len(all)+1)
+	for _, ins := range all {
+		if !ins.expired(now) {
+			alive = append(alive, ins)
+		}
+	}
+	all = fn(alive)
+
+	data, err := json.MarshalIndent(all, "", "  ")
+	if err != nil {
+		return err
+	}
+	// 先写临时文件再重命名，读取方不会读到写了一半的文件
+	if err := r.writeFile(data); err != nil {
+		return err
+	}
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return err
+	}
+	r.apply(all, info)
+	return nil
+}
+
+// lockFile 以 O_EXCL 创建锁文件，已存在时等待其他进程删除
+func (r *FileRegistry) lockFile() (func(), error) {
+	lock := r.path + ".lock"
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		f, err := os.OpenFile(lock, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(lock) }, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, err
+		}
+		if info, err := os.Stat(lock); err == nil && time.Since(info.ModTime()) > staleLockAge {
+			os.Remove(lock)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("wait for %s timeout", filepath.Base(lock))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// writeFile 每次写入使用不同的临时文件，完成后重命名为 r.path
+func (r *FileRegistry) writeFile(data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(r.path), filepath.Base(r.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Chmod(0644)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, r.path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// readFile 调用时需要持有 r.mu
+func (r *FileRegistry) readFile() ([]fileInstance, error) {
+	data, err := os.ReadFile(r.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var all []fileInstance
+	if len(data) == 0 {
+		return all, nil
+	}
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, fmt.Errorf("parse %s error: %w", filepath.Base(r.path), err)
+	}
+	return all, nil
+}
+
+// apply 更新没有过期的实例，通知有变化的服务，调用时需要持有 r.mu
+func (r *FileRegistry) apply(all []fileInstance, info os.FileInfo) {
+	now := time.Now()
+	instances := make(map[string][]Instance)
+	for _, ins := range all {
+		if !ins.expired(now) {
+			instances[ins.Service] = append(instances[ins.Service], ins.Instance)
+		}
+	}
+	for _, list := range instances {
+		sortInstances(list)
+	}
+
+	for _, service := range r.watchers.services() {
+		if !reflect.DeepEqual(instances[service], r.instances[service]) {
+			r.watchers.notify(service, append([]Instance{}, instances[service]...))
+		}
+	}
+
+	r.raw = all
+	r.instances = instances
+	r.modTime, r.size = time.Time{}, 0
+	if info != nil {
+		r.modTime, r.size = info.ModTime(), info.Size()
+	}
+}
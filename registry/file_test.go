@@ -0,0 +1,82 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileRegistry(t *testing.T) {
+	r, err := NewFileRegistry(filepath.Join(t.TempDir(), "registry.json"), 10*time.Millisecond)
+	assert.Equal(t, nil, err)
+	defer r.Close()
+	testRegistry(t, r)
+
+	r, err = NewFileRegistry(filepath.Join(t.TempDir(), "registry.json"), 10*time.Millisecond)
+	assert.Equal(t, nil, err)
+	defer r.Close()
+	testRegistryTTL(t, r)
+}
+
+func TestFileRegistryExternalChange(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.json")
+	r, err := NewFileRegistry(path, 10*time.Millisecond)
+	assert.Equal(t, nil, err)
+	defer r.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := r.Watch(ctx, "User")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(recv(t, ch)))
+
+	// 其他进程修改了文件
+	err = os.WriteFile(path, []byte(`[{"service": "User", "addr": "127.0.0.1:6060", "metadata": {"zone": "sh"}}]`), 0644)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []Instance{{Service: "User", Addr: "127.0.0.1:6060", Metadata: map[string]string{"zone": "sh"}}}, recv(t, ch))
+
+	// 格式错误的文件不影响已有的实例
+	err = os.WriteFile(path, []byte(`[{"service": `), 0644)
+	assert.Equal(t, nil, err)
+	time.Sleep(50 * time.Millisecond)
+	list, err := r.List("User")
+	assert.NotEqual(t, nil, err)
+	assert.Equal(t, 0, len(list))
+	r.mu.Lock()
+	assert.Equal(t, 1, len(r.instances["User"]))
+	r.mu.Unlock()
+}
+
+func TestFileRegistrySharedWrite(t *testing.T) {
+	// 多个 FileRegistry 模拟共享同一个文件的多个进程，同时注册的实例都不会丢失
+	path := filepath.Join(t.TempDir(), "registry.json")
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		r, err := NewFileRegistry(path, time.Second)
+		assert.Equal(t, nil, err)
+		defer r.Close()
+		for j := 0; j < 10; j++ {
+			wg.Add(1)
+			go func(addr string) {
+				defer wg.Done()
+				assert.Equal(t, nil, r.Register(Instance{Service: "User", Addr: addr}))
+			}(fmt.Sprintf("127.0.0.1:%d", 6000+i*10+j))
+		}
+	}
+	wg.Wait()
+
+	r, err := NewFileRegistry(path, time.Second)
+	assert.Equal(t, nil, err)
+	defer r.Close()
+	list, err := r.List("User")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 40, len(list))
+	_, err = os.Stat(path + ".lock")
+	assert.Equal(t, true, os.IsNotExist(err))
+}
@@ -0,0 +1,103 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// MemoryRegistry 进程内的注册中心，用于测试或者客户端和服务端在同一个进程中
+type MemoryRegistry struct {
+	mu       sync.Mutex
+	services map[string]map[string]*memoryEntry // service -> addr -> instance
+	watchers watchers
+}
+
+type memoryEntry struct {
+	ins   Instance
+	timer *time.Timer // TTL 到期后删除实例
+}
+
+func NewMemoryRegistry() *MemoryRegistry {
+	return &MemoryRegistry{
+		services: make(map[string]map[string]*memoryEntry),
+	}
+}
+
+func (r *MemoryRegistry) Register(ins Instance) error {
+	if ins.Service == "" || ins.Addr == "" {
+		return fmt.Errorf("service and addr are required")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.services[ins.Service] == nil {
+		r.services[ins.Service] = make(map[string]*memoryEntry)
+	}
+	old := r.services[ins.Service][ins.Addr]
+	if old != nil && old.timer != nil {
+		old.timer.Stop()
+	}
+
+	e := &memoryEntry{ins: ins}
+	if ins.TTL > 0 {
+		e.timer = time.AfterFunc(ins.TTL, func() {
+			r.expire(e)
+		})
+	}
+	r.services[ins.Service][ins.Addr] = e
+	// 续期不通知
+	if old == nil || !reflect.DeepEqual(old.ins, ins) {
+		r.watchers.notify(ins.Service, r.list(ins.Service))
+	}
+	return nil
+}
+
+// expire 实例没有在 TTL 内续期
+func (r *MemoryRegistry) expire(e *memoryEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.services[e.ins.Service][e.ins.Addr] != e {
+		return
+	}
+	delete(r.services[e.ins.Service], e.ins.Addr)
+	r.watchers.notify(e.ins.Service, r.list(e.ins.Service))
+}
+
+func (r *MemoryRegistry) Deregister(ins Instance) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e, ok := r.services[ins.Service][ins.Addr]
+	if !ok {
+		return nil
+	}
+	if e.timer != nil {
+		e.timer.Stop()
+	}
+	delete(r.services[ins.Service], ins.Addr)
+	r.watchers.notify(ins.Service, r.list(ins.Service))
+	return nil
+}
+
+func (r *MemoryRegistry) List(service string) ([]Instance, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.list(service), nil
+}
+
+func (r *MemoryRegistry) Watch(ctx context.Context, service string) (<-chan []Instance, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.watchers.add(ctx, service, r.list(service)), nil
+}
+
+// list 调用时需要持有 r.mu
+func (r *MemoryRegistry) list(service string) []Instance {
+	instances := make([]Instance, 0, len(r.services[service]))
+	for _, e := range r.services[service] {
+		instances = append(instances, e.ins)
+	}
+	sortInstances(instances)
+	return instances
+}
@@ -0,0 +1,85 @@
+package registry
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testRegistry 注册中心的通用测试
+func testRegistry(t *testing.T, r Registry) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := r.Watch(ctx, "User")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(recv(t, ch)))
+
+	a := Instance{Service: "User", Addr: "127.0.0.1:6061", Metadata: map[string]string{"version": "v1"}}
+	b := Instance{Service: "User", Addr: "127.0.0.1:6060"}
+	assert.Equal(t, nil, r.Register(a))
+	assert.Equal(t, []Instance{a}, recv(t, ch))
+	assert.Equal(t, nil, r.Register(b))
+	assert.Equal(t, []Instance{b, a}, recv(t, ch))
+	// 其他服务的变化不会通知
+	assert.Equal(t, nil, r.Register(Instance{Service: "Order", Addr: "127.0.0.1:6060"}))
+
+	list, err := r.List("User")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []Instance{b, a}, list)
+
+	assert.Equal(t, nil, r.Deregister(b))
+	assert.Equal(t, []Instance{a}, recv(t, ch))
+
+	// ctx结束后关闭通道
+	cancel()
+	select {
+	case _, ok := <-ch:
+		assert.False(t, ok)
+	case <-time.After(time.Second):
+		t.Fatal("watch channel is not closed")
+	}
+}
+
+// testRegistryTTL 没有续期的实例过期后被删除
+func testRegistryTTL(t *testing.T, r Registry) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := r.Watch(ctx, "User")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(recv(t, ch)))
+
+	ins := Instance{Service: "User", Addr: "127.0.0.1:6060", TTL: 100 * time.Millisecond}
+	assert.Equal(t, nil, r.Register(ins))
+	assert.Equal(t, []Instance{ins}, recv(t, ch))
+
+	// 续期
+	for i := 0; i < 4; i++ {
+		time.Sleep(50 * time.Millisecond)
+		assert.Equal(t, nil, r.Register(ins))
+	}
+	list, err := r.List("User")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []Instance{ins}, list)
+
+	// 停止续期
+	assert.Equal(t, 0, len(recv(t, ch)))
+	list, err = r.List("User")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(list))
+}
+
+func recv(t *testing.T, ch <-chan []Instance) []Instance {
+	select {
+	case instances := <-ch:
+		return instances
+	case <-time.After(2 * time.Second):
+		t.Fatal("no instances received")
+	}
+	return nil
+}
+
+func TestMemoryRegistry(t *testing.T) {
+	testRegistry(t, NewMemoryRegistry())
+	testRegistryTTL(t, NewMemoryRegistry())
+}
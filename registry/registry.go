@@ -0,0 +1,35 @@
+package registry
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// Instance 一个服务实例
+type Instance struct {
+	Service  string            `json:"service"` // 服务名（注册的对象名 ObjectName）
+	Addr     string            `json:"addr"`
+	Metadata map[string]string `json:"metadata,omitempty"` // 版本、权重、机房等信息
+	TTL      time.Duration     `json:"ttl,omitempty"`      // 存活时间，过期前需要重新 Register 续期，0表示不过期
+}
+
+// Registry 服务注册与发现
+type Registry interface {
+	// Register 注册服务实例，相同的 Service 和 Addr 会覆盖之前的实例（也用于 TTL 续期）
+	Register(ins Instance) error
+	// Deregister 注销服务实例
+	Deregister(ins Instance) error
+	// List 服务当前的所有实例
+	List(service string) ([]Instance, error)
+	// Watch 先发送服务当前的所有实例，之后每次变化时发送全部实例，ctx结束时关闭通道
+	// 通道只保留最新的实例列表，读取不及时会跳过中间的变化
+	Watch(ctx context.Context, service string) (<-chan []Instance, error)
+}
+
+// sortInstances 按照地址排序，保证 List 的结果稳定
+func sortInstances(instances []Instance) {
+	sort.Slice(instances, func(i, j int) bool {
+		return instances[i].Addr < instances[j].Addr
+	})
+}
@@ -0,0 +1,63 @@
+package registry
+
+import (
+	"context"
+	"sync"
+)
+
+// watchers 服务的订阅者
+type watchers struct {
+	mu   sync.Mutex
+	subs map[string]map[chan []Instance]struct{}
+}
+
+// add 订阅服务，current 作为第一次发送的实例列表
+func (w *watchers) add(ctx context.Context, service string, current []Instance) <-chan []Instance {
+	ch := make(chan []Instance, 1)
+	ch <- current
+
+	w.mu.Lock()
+	if w.subs == nil {
+		w.subs = make(map[string]map[chan []Instance]struct{})
+	}
+	if w.subs[service] == nil {
+		w.subs[service] = make(map[chan []Instance]struct{})
+	}
+	w.subs[service][ch] = struct{}{}
+	w.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		delete(w.subs[service], ch)
+		close(ch)
+	}()
+	return ch
+}
+
+// notify 通知订阅者服务的最新实例，丢弃还没有被读取的旧列表
+func (w *watchers) notify(service string, instances []Instance) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for ch := range w.subs[service] {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- instances
+	}
+}
+
+// services 有订阅者的服务
+func (w *watchers) services() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	services := make([]string, 0, len(w.subs))
+	for service, subs := range w.subs {
+		if len(subs) > 0 {
+			services = append(services, service)
+		}
+	}
+	return services
+}
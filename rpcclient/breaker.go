@@ -0,0 +1,211 @@
+package rpcclient
+
+import (
+	"sync"
+	"time"
+)
+
+// BreakerState 熔断器状态
+type BreakerState int
+
+const (
+	BreakerClosed   BreakerState = iota // 正常发送请求
+	BreakerOpen                         // 熔断，请求直接失败
+	BreakerHalfOpen                     // 熔断超时后，允许少量试探请求
+)
+
+func (state BreakerState) String() string {
+	switch state {
+	case BreakerClosed:
+		return "Closed"
+	case BreakerOpen:
+		return "Open"
+	case BreakerHalfOpen:
+		return "HalfOpen"
+	}
+	return "Unknown"
+}
+
+// BreakerHook 熔断器状态变化时回调（同步执行，不能阻塞，也不能调用 RPCClient 的方法）
+type BreakerHook func(addr string, state BreakerState)
+
+// BreakerOption 每个地址一个熔断器，连续失败次数或者滑动窗口内的错误率达到阈值时熔断
+// 只统计网络类错误（连接失败、超时、服务关闭中），服务端方法返回的错误不算失败
+type BreakerOption struct {
+	ConsecutiveFailures int           // 连续失败次数达到该值时熔断，0表示不使用
+	ErrorRate           float64       // 窗口内错误率（0~1）达到该值时熔断，0表示不使用
+	MinRequests         int           // 按照错误率熔断时，窗口内的最少请求数
+	Window              time.Duration // 统计错误率的滑动窗口
+	OpenTimeout         time.Duration // 熔断后经过该时间进入半开状态
+	HalfOpenRequests    int           // 半开状态允许的试探请求数，全部成功后恢复，任意一个失败则重新熔断
+	Hook                BreakerHook   // 状态变化回调，可以为nil
+}
+
+var DefaultBreakerOption = BreakerOption{
+	ConsecutiveFailures: 5,
+	ErrorRate:           0.5,
+	MinRequests:         20,
+	Window:              10 * time.Second,
+	OpenTimeout:         5 * time.Second,
+	HalfOpenRequests:    1,
+}
+
+// breakerBuckets 滑动窗口的分桶个数
+const breakerBuckets = 10
+
+type breakerBucket struct {
+	start    int64 // 桶的开始时间
+	total    int
+	failures int
+}
+
+type breaker struct {
+	addr   string
+	option BreakerOption
+
+	mu          sync.Mutex
+	state       BreakerState
+	generation  uint64 // 每次状态变化加1，忽略旧状态下发出的请求的结果
+	consecutive int    // 连续失败次数
+	buckets     [breakerBuckets]breakerBucket
+	openedAt    time.Time
+	probes      int // 半开状态已经发出的试探请求
+	successes   int // 半开状态成功的试探请求
+}
+
+// newBreaker option 为nil时不熔断，返回nil
+func newBreaker(addr string, option *BreakerOption) *breaker {
+	if option == nil {
+		return nil
+	}
+	b := &breaker{addr: addr, option: *option}
+	if b.option.HalfOpenRequests <= 0 {
+		b.option.HalfOpenRequests = 1
+	}
+	if b.option.Window <= 0 {
+		b.option.Window = DefaultBreakerOption.Window
+	}
+	// 每个分桶至少1纳秒
+	if b.option.Window < breakerBuckets {
+		b.option.Window = breakerBuckets
+	}
+	return b
+}
+
+// allow 是否可以发送请求，返回的 generation 用于 record
+func (b *breaker) allow() (uint64, bool) {
+	if b == nil {
+		return 0, true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerOpen {
+		if time.Since(b.openedAt) < b.option.OpenTimeout {
+			return 0, false
+		}
+		b.setState(BreakerHalfOpen)
+	}
+	if b.state == BreakerHalfOpen {
+		if b.probes >= b.option.HalfOpenRequests {
+			return 0, false
+		}
+		b.probes++
+	}
+	return b.generation, true
+}
+
+// record 记录请求的结果
+func (b *breaker) record(generation uint64, success bool) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if generation != b.generation {
+		return
+	}
+
+	switch b.state {
+	case BreakerHalfOpen:
+		if !success {
+			b.setState(BreakerOpen)
+			return
+		}
+		b.successes++
+		if b.successes >= b.option.HalfOpenRequests {
+			b.setState(BreakerClosed)
+		}
+	case BreakerClosed:
+		bucket := b.bucket(time.Now())
+		bucket.total++
+		if success {
+			b.consecutive = 0
+			return
+		}
+		bucket.failures++
+		b.consecutive++
+		if b.shouldTrip() {
+			b.setState(BreakerOpen)
+		}
+	}
+}
+
+// release 请求没有结果（例如调用方取消），归还半开状态的试探名额
+func (b *breaker) release(generation uint64) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if generation == b.generation && b.state == BreakerHalfOpen && b.probes > 0 {
+		b.probes--
+	}
+}
+
+// bucket 当前时间所在的桶，调用时需要持有 b.mu
+func (b *breaker) bucket(now time.Time) *breakerBucket {
+	size := int64(b.option.Window) / breakerBuckets
+	start := now.UnixNano() / size * size
+	bucket := &b.buckets[(start/size)%breakerBuckets]
+	if bucket.start != start {
+		*bucket = breakerBucket{start: start}
+	}
+	return bucket
+}
+
+// shouldTrip 调用时需要持有 b.mu
+func (b *breaker) shouldTrip() bool {
+	if b.option.ConsecutiveFailures > 0 && b.consecutive >= b.option.ConsecutiveFailures {
+		return true
+	}
+	if b.option.ErrorRate <= 0 {
+		return false
+	}
+	total, failures := 0, 0
+	windowStart := time.Now().Add(-b.option.Window).UnixNano()
+	for _, bucket := range b.buckets {
+		if bucket.start > windowStart {
+			total += bucket.total
+			failures += bucket.failures
+		}
+	}
+	return total > 0 && total >= b.option.MinRequests && float64(failures)/float64(total) >= b.option.ErrorRate
+}
+
+// setState 调用时需要持有 b.mu
+func (b *breaker) setState(state BreakerState) {
+	b.state = state
+	b.generation++
+	b.probes, b.successes = 0, 0
+	switch state {
+	case BreakerOpen:
+		b.openedAt = time.Now()
+	case BreakerClosed:
+		b.consecutive = 0
+		b.buckets = [breakerBuckets]breakerBucket{}
+	}
+	if b.option.Hook != nil {
+		b.option.Hook(b.addr, state)
+	}
+}
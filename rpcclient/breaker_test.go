@@ -0,0 +1,172 @@
+package rpcclient
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gofish2020/easyrpc/registry"
+	"github.com/gofish2020/easyrpc/rpcmsg"
+	"github.com/stretchr/testify/assert"
+)
+
+func call(b *breaker, success bool) bool {
+	generation, ok := b.allow()
+	if ok {
+		b.record(generation, success)
+	}
+	return ok
+}
+
+func TestBreakerConsecutiveFailures(t *testing.T) {
+	var states []BreakerState
+	b := newBreaker("addr", &BreakerOption{
+		ConsecutiveFailures: 3,
+		OpenTimeout:         50 * time.Millisecond,
+		HalfOpenRequests:    2,
+		Hook: func(addr string, state BreakerState) {
+			states = append(states, state)
+		},
+	})
+
+	// 成功的请求重置连续失败次数
+	call(b, false)
+	call(b, false)
+	call(b, true)
+	call(b, false)
+	call(b, false)
+	assert.Equal(t, BreakerClosed, b.state)
+	call(b, false)
+	assert.Equal(t, BreakerOpen, b.state)
+	assert.False(t, call(b, true))
+
+	// 半开：试探请求失败，重新熔断
+	time.Sleep(60 * time.Millisecond)
+	assert.True(t, call(b, false))
+	assert.Equal(t, BreakerOpen, b.state)
+
+	// 半开：只允许 HalfOpenRequests 个试探请求，全部成功后恢复
+	time.Sleep(60 * time.Millisecond)
+	g1, ok1 := b.allow()
+	g2, ok2 := b.allow()
+	_, ok3 := b.allow()
+	assert.True(t, ok1 && ok2)
+	assert.False(t, ok3)
+	b.record(g1, true)
+	assert.Equal(t, BreakerHalfOpen, b.state)
+	b.record(g2, true)
+	assert.Equal(t, BreakerClosed, b.state)
+
+	assert.Equal(t, []BreakerState{BreakerOpen, BreakerHalfOpen, BreakerOpen, BreakerHalfOpen, BreakerClosed}, states)
+}
+
+func TestBreakerErrorRate(t *testing.T) {
+	b := newBreaker("addr", &BreakerOption{
+		ErrorRate:   0.5,
+		MinRequests: 10,
+		Window:      time.Second,
+		OpenTimeout: time.Second,
+	})
+
+	// 请求数不足
+	for i := 0; i < 4; i++ {
+		call(b, false)
+		call(b, true)
+	}
+	assert.Equal(t, BreakerClosed, b.state)
+	call(b, true)
+	assert.Equal(t, BreakerClosed, b.state)
+	// 10个请求，5个失败
+	call(b, false)
+	assert.Equal(t, BreakerOpen, b.state)
+
+	// 熔断前发出的请求结果被忽略
+	b.record(0, true)
+	assert.Equal(t, BreakerOpen, b.state)
+
+	// 窗口小于分桶个数纳秒时不会除以0
+	b = newBreaker("addr", &BreakerOption{ErrorRate: 0.5, MinRequests: 10, Window: 5 * time.Nanosecond})
+	assert.Equal(t, time.Duration(breakerBuckets), b.option.Window)
+	assert.NotPanics(t, func() {
+		for i := 0; i < 20; i++ {
+			call(b, false)
+		}
+	})
+}
+
+func TestBreakerFailover(t *testing.T) {
+	// 服务A收到请求后关闭连接，服务B正常返回
+	serverA := fakeServer(t, func(conn net.Conn) {
+		rpcmsg.RecvFrom(conn)
+		conn.Close()
+	})
+	defer serverA.Close()
+	serverB := fakeServer(t, func(conn net.Conn) {
+		defer conn.Close()
+		for {
+			msg, err := rpcmsg.RecvFrom(conn)
+			if err != nil {
+				return
+			}
+			reply(conn, msg, "B")
+		}
+	})
+	defer serverB.Close()
+
+	reg := registry.NewMemoryRegistry()
+	reg.Register(registry.Instance{Service: "Server", Addr: serverA.Addr().String()})
+	reg.Register(registry.Instance{Service: "Server", Addr: serverB.Addr().String()})
+
+	opened := make(chan string, 4)
+	option := DefaultOption
+	option.Registry = reg
+	option.SelectMode = RoundRobin
+	option.Breaker = &BreakerOption{
+		ConsecutiveFailures: 2,
+		OpenTimeout:         time.Minute,
+		Hook: func(addr string, state BreakerState) {
+			if state == BreakerOpen {
+				opened <- addr
+			}
+		},
+	}
+
+	// Failfast：服务A熔断后直接返回 ErrCircuitOpen
+	option.FailMode = Failfast
+	client := NewRPCClient(option)
+	defer client.Close()
+	var who func() (string, error)
+	_, err := client.Call(context.Background(), "Server.Who", &who)
+	assert.Equal(t, nil, err)
+	var serverErr, circuitOpen int
+	for i := 0; i < 20; i++ {
+		res, err := who()
+		switch {
+		case err == nil:
+			assert.Equal(t, "B", res)
+		case errors.Is(err, ErrCircuitOpen):
+			circuitOpen++
+		default:
+			assert.ErrorIs(t, err, ErrServer)
+			serverErr++
+		}
+	}
+	assert.LessOrEqual(t, serverErr, 2)
+	assert.GreaterOrEqual(t, circuitOpen, 8)
+	assert.Equal(t, serverA.Addr().String(), <-opened)
+
+	// Failover：服务A熔断后请求发送到服务B
+	option.FailMode = Failover
+	client = NewRPCClient(option)
+	defer client.Close()
+	_, err = client.Call(context.Background(), "Server.Who", &who)
+	assert.Equal(t, nil, err)
+	for i := 0; i < 10; i++ {
+		res, err := who()
+		assert.Equal(t, nil, err)
+		assert.Equal(t, "B", res)
+	}
+	assert.Equal(t, serverA.Addr().String(), <-opened)
+}
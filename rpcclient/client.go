@@ -2,119 +2,313 @@ package rpcclient
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
-	"net"
 	"reflect"
 	"strings"
 	"sync"
 	"sync/atomic"
-	"time"
 
+	"github.com/gofish2020/easyrpc/metadata"
 	"github.com/gofish2020/easyrpc/rpcmsg"
 )
 
 type Client interface {
-	Connect(addr string) error
-	Call()
+	Connect(addrs ...string) error
+	Call(ctx context.Context, servicePath string, stub interface{}, params ...interface{}) (interface{}, error)
 	Close()
 }
 
 func NewRPCClient(option Option) *RPCClient {
+	// 没有设置协议版本时使用当前版本，否则不会发送 metadata
+	if option.Version == 0 {
+		option.Version = rpcmsg.Version
+	}
 	return &RPCClient{
-		option:         option,
-		waiting:        make(map[int64]*waitMsg),
-		serverShutdown: 0,
-		clientClose:    0,
+		option:      option,
+		services:    make(map[string]*resolver),
+		clientClose: 0,
 	}
 }
 
 type RPCClient struct {
-	conn   net.Conn
 	option Option
-	addr   string
-
-	mutex sync.Mutex // 发送的并发控制
 
-	mu      sync.RWMutex // map的并发控制
-	waiting map[int64]*waitMsg
+	mu       sync.Mutex           // static/services的并发控制
+	static   *resolver            // Connect 指定的地址，所有服务共用
+	services map[string]*resolver // 通过 Registry 发现的服务地址
+	watchCtx context.Context      // Registry 的 Watch，Close 时结束
+	cancel   context.CancelFunc
 
-	serverShutdown int32
-	clientClose    int32
+	clientClose int32
 }
 
-func (client *RPCClient) addWaitMsg(wMsg *waitMsg) {
+// Connect 连接服务端：按顺序连接第一个可用地址，其余地址作为 Failover 的备选
+func (client *RPCClient) Connect(addrs ...string) error {
+	if len(addrs) == 0 {
+		return fmt.Errorf("at least one address is required")
+	}
+
 	client.mu.Lock()
 	defer client.mu.Unlock()
-	client.waiting[wMsg.GetSeq()] = wMsg
+
+	// 重新连接时，关闭旧的连接
+	if client.static != nil {
+		client.static.close()
+	}
+	client.static = newResolver(client.option)
+	if err := client.static.connect(addrs); err != nil {
+		// 连接失败时关闭（停止后台重连），需要重新调用 Connect
+		client.static.close()
+		client.static = nil
+		atomic.CompareAndSwapInt32(&client.clientClose, 0, 1)
+		return err
+	}
+	atomic.CompareAndSwapInt32(&client.clientClose, 1, 0)
+	return nil
 }
 
-func (client *RPCClient) removeWaitMsg(seq int64) *waitMsg {
+func (client *RPCClient) Close() {
+	atomic.CompareAndSwapInt32(&client.clientClose, 0, 1)
 	client.mu.Lock()
 	defer client.mu.Unlock()
-	wMsg := client.waiting[seq]
-	delete(client.waiting, seq)
-	return wMsg
+	if client.static != nil {
+		client.static.close()
+	}
+	if client.cancel != nil {
+		client.cancel()
+		client.watchCtx, client.cancel = nil, nil
+	}
+	for _, r := range client.services {
+		r.close()
+	}
+	client.services = make(map[string]*resolver)
 }
 
-func (client *RPCClient) removeAllWaitMsg() {
-	atomic.CompareAndSwapInt32(&client.serverShutdown, 0, 1)
+// resolve 获取服务的地址：设置了 Registry 时按照对象名发现，否则使用 Connect 指定的地址
+func (client *RPCClient) resolve(service string) (*resolver, error) {
 	client.mu.Lock()
 	defer client.mu.Unlock()
-	for _, waitMsg := range client.waiting {
-		waitMsg.Ready(nil)
+
+	if client.option.Registry == nil {
+		if client.static == nil {
+			return nil, ErrClient
+		}
+		return client.static, nil
+	}
+
+	if r, ok := client.services[service]; ok {
+		return r, nil
+	}
+	if client.watchCtx == nil {
+		client.watchCtx, client.cancel = context.WithCancel(context.Background())
 	}
-	client.waiting = make(map[int64]*waitMsg)
+	r := newResolver(client.option)
+	if err := r.watch(client.watchCtx, client.option.Registry, service); err != nil {
+		return nil, err
+	}
+	client.services[service] = r
+	return r, nil
 }
 
-func (client *RPCClient) loopWaitMsg() {
-	for {
-		resMsg, err := rpcmsg.RecvFrom(client.conn)
-		if err != nil {
+// onRetry 重试通知
+func (client *RPCClient) onRetry(servicePath, addr string, attempt int, err error) {
+	if client.option.RetryHook != nil {
+		client.option.RetryHook(servicePath, addr, attempt, err)
+		return
+	}
+	log.Printf("%s call %s failed: %+v, retry %d (%s)\n", servicePath, addr, err, attempt, client.option.FailMode)
+}
+
+// invoke 根据 FailMode 发送请求，只对网络类错误进行重试
+func (client *RPCClient) invoke(ctx context.Context, servicePath string, payload []byte, conf rpcmsg.RPCMsgConfig) (*rpcmsg.RPCMsg, error) {
+	failMode := client.option.FailMode
+	retries := client.option.Retries
+	if failMode == Failfast || retries < 0 {
+		retries = 0
+	}
+
+	r, err := client.resolve(conf.ObjectName)
+	if err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+	var ep *endpoint
+	failed := make(map[string]bool) // 本次请求失败过的地址
+	// skip 跳过地址，还有其他没有失败过的地址时返回true
+	skip := func(addr string) bool {
+		failed[addr] = true
+		r.failover(addr)
+		return len(failed) < r.size()
+	}
+	for attempt := 0; ; attempt++ {
+		// Failretry 在同一个地址重试，其余模式每次按照负载均衡策略选择地址（跳过失败过的地址）
+		if ep == nil || failMode != Failretry {
+			if ep, err = r.pick(ctx, conf.ObjectName, failed); err != nil {
+				return nil, err
+			}
+		}
+		// 熔断中的地址：Failover 时换一个地址（不算重试），否则直接失败
+		generation, ok := ep.breaker.allow()
+		if !ok {
+			if failMode == Failover && skip(ep.addr) {
+				ep = nil
+				attempt--
+				continue
+			}
+			return nil, fmt.Errorf("%w: %s", ErrCircuitOpen, ep.addr)
+		}
+
+		// 第一次请求不主动重连（保持 Failfast 的语义），重试时才重新建立连接
+		c, err := r.getConn(ctx, ep, attempt > 0)
+		unavailable := false
+		if err == nil {
+			var resMsg *rpcmsg.RPCMsg
+			resMsg, err = c.call(ctx, payload, conf)
+			if err == nil && resMsg.Error != nil && resMsg.Error.Code == rpcmsg.CodeUnavailable {
+				err = newRemoteError(resMsg.Error) // 服务端关闭中，请求没有执行
+				unavailable = true
+			} else if err == nil {
+				ep.breaker.record(generation, true)
+				return resMsg, nil
+			}
+		}
+		// 连接刚收到GoAway、服务端关闭中拒绝了请求或者地址已经被移除，请求没有执行，重新选择（不算重试）
+		if errors.Is(err, errConnDraining) || ((unavailable || (c == nil && ep.isGoingAway())) && skip(ep.addr)) {
+			ep.breaker.release(generation)
+			ep = nil
+			attempt--
+			continue
+		}
+		if errors.Is(err, ErrClient) || errors.Is(ctx.Err(), context.Canceled) {
+			ep.breaker.release(generation)
+			return nil, err
+		}
+		if unavailable {
+			ep.breaker.release(generation) // 服务端关闭中，不是地址的故障
+		} else {
+			ep.breaker.record(generation, false)
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		lastErr = err
+		if attempt >= retries {
 			break
 		}
-		wMsg := client.removeWaitMsg(resMsg.Seq)
-		if wMsg != nil { // 说明这个序列号，不存在
-			wMsg.Ready(resMsg)
+
+		client.onRetry(servicePath, ep.addr, attempt+1, err)
+		if failMode == Failover {
+			skip(ep.addr)
 		}
 	}
-	client.removeAllWaitMsg()
+	return nil, lastErr
 }
-func (client *RPCClient) Connect(addr string) error {
-	conn, err := net.DialTimeout(client.option.Network, addr, client.option.ConnectTimeout)
+
+// send 编码参数、发送请求并等待响应、按照 outTypes 解码结果
+func (client *RPCClient) send(ctx context.Context, servicePath string, args []interface{}, outTypes []reflect.Type) ([]interface{}, error) {
+	serviceInfo := strings.Split(servicePath, ".")
+	if len(serviceInfo) != 2 {
+		return nil, fmt.Errorf("servicePath format is splitted by point ObjectXXX.MethodXXX")
+	}
+	// 序列化器
+	codeTool := rpcmsg.LookupCodec(client.option.SerializeType)
+	if codeTool == nil {
+		return nil, fmt.Errorf("unsupported serialize type %d (not registered)", client.option.SerializeType)
+	}
+	encodeRes, err := rpcmsg.EncodeArgs(codeTool, args)
 	if err != nil {
-		atomic.CompareAndSwapInt32(&client.clientClose, 0, 1)
-		atomic.CompareAndSwapInt32(&client.serverShutdown, 0, 1)
-		return err
+		log.Printf("encode err:%+v\n", err)
+		return nil, err
+	}
+	// 压缩（小于 CompressThreshold 时不压缩），数据包头设置实际使用的压缩类型
+	compressType, payload, err := rpcmsg.CompressWith(client.option.Compressors, client.option.CompressType, client.option.CompressThreshold, encodeRes)
+	if err != nil {
+		log.Printf("compress err:%+v\n", err)
+		return nil, err
 	}
-	client.conn = conn
-	client.addr = addr
-	atomic.CompareAndSwapInt32(&client.clientClose, 1, 0)
-	atomic.CompareAndSwapInt32(&client.serverShutdown, 1, 0)
-	go client.loopWaitMsg()
-	return nil
-}
 
-func (client *RPCClient) Close() {
-	atomic.CompareAndSwapInt32(&client.clientClose, 0, 1)
-	if client.conn != nil {
-		client.conn.Close()
+	// 发送请求
+	conf := rpcmsg.RPCMsgConfig{
+		MsgTypeConf:       rpcmsg.Request,
+		CompressTypeConf:  compressType,
+		SerializeTypeConf: client.option.SerializeType,
+		VersionConf:       client.option.Version,
+		ObjectName:        serviceInfo[0],
+		MethodName:        serviceInfo[1],
+	}
+	// 随请求发送的 metadata（服务端是旧版本时会忽略）
+	conf.Metadata, _ = metadata.FromOutgoingContext(ctx)
+
+	// 获取请求
+	resMsg, err := client.invoke(ctx, servicePath, payload, conf)
+	if err != nil {
+		return nil, err
+	}
+	// 服务端返回的 trailer，保存到 metadata.WithTrailer 设置的位置
+	metadata.SetReceivedTrailer(ctx, resMsg.Metadata)
+	// 服务端方法返回了错误
+	if resMsg.Error != nil {
+		return nil, newRemoteError(resMsg.Error)
+	}
+	// 按照响应数据包头的压缩类型解压缩
+	compressRes, err := rpcmsg.UnCompress(resMsg.CompressType(), resMsg.Payload)
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := rpcmsg.DecodeArgs(codeTool, compressRes, outTypes)
+	if err != nil {
+		return nil, err
 	}
+	results := make([]interface{}, len(values))
+	for i := range values {
+		results[i] = values[i].Interface()
+	}
+	return results, nil
 }
 
-// servicePath
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// Call 为 stub 函数指针生成存根，并用 params 执行一次
+// servicePath 格式 ObjectXXX.MethodXXX
+// stub 的第一个参数如果是 context.Context，每次调用使用传入的ctx（不会发送给服务端），否则使用 Call 时的ctx
 func (client *RPCClient) Call(ctx context.Context, servicePath string, stub interface{}, params ...interface{}) (interface{}, error) {
 	serviceInfo := strings.Split(servicePath, ".")
 	if len(serviceInfo) != 2 {
 		return nil, fmt.Errorf("servicePath format is splitted by point ObjectXXX.MethodXXX")
 	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
 
-	// stub 函数指针
-	funcValue := reflect.ValueOf(stub).Elem()
+	// stub 函数指针，最后一个返回值必须是error
+	stubValue := reflect.ValueOf(stub)
+	if stubValue.Kind() != reflect.Ptr || stubValue.Elem().Kind() != reflect.Func {
+		return nil, fmt.Errorf("stub must be a pointer to func")
+	}
+	funcValue := stubValue.Elem()
+	if numOut := funcValue.Type().NumOut(); numOut == 0 || funcValue.Type().Out(numOut-1) != errorType {
+		return nil, fmt.Errorf("the last return value of stub must be error")
+	}
+	withCtx := funcValue.Type().NumIn() > 0 && funcValue.Type().In(0) == contextType
 
-	fn := func(args []reflect.Value) (results []reflect.Value) {
+	numOut := funcValue.Type().NumOut()
+	// 按照 stub 的返回值类型反序列化（不包括最后的error）
+	outTypes := make([]reflect.Type, numOut-1)
+	for i := range outTypes {
+		outTypes[i] = funcValue.Type().Out(i)
+	}
+	invoker := chainInterceptors(client.option.Interceptors, func(ctx context.Context, servicePath string, args []interface{}) ([]interface{}, error) {
+		return client.send(ctx, servicePath, args, outTypes)
+	})
 
-		numOut := funcValue.Type().NumOut()
+	fn := func(args []reflect.Value) (results []reflect.Value) {
 
 		errorHandler := func(err error) []reflect.Value {
 			argsOut := make([]reflect.Value, numOut)
@@ -129,99 +323,61 @@ func (client *RPCClient) Call(ctx context.Context, servicePath string, stub inte
 		if atomic.LoadInt32(&client.clientClose) == 1 {
 			return errorHandler(ErrClient)
 		}
-		if atomic.LoadInt32(&client.serverShutdown) == 1 {
-			return errorHandler(ErrServer)
-		}
 
-		// 入参
-		argsIn := make([]interface{}, 0, len(args))
-		for _, arg := range args {
-			argsIn = append(argsIn, arg.Interface())
+		callCtx := ctx
+		if withCtx {
+			if c, ok := args[0].Interface().(context.Context); ok && c != nil {
+				callCtx = c
+			} else {
+				callCtx = context.Background()
+			}
+			args = args[1:]
 		}
-		// 序列化器
-		codeTool := rpcmsg.Codecs[client.option.SerializeType]
-		encodeRes, err := codeTool.Encode(argsIn)
-		if err != nil {
-			log.Printf("encode err:%+v\n", err)
+		if err := callCtx.Err(); err != nil {
 			return errorHandler(err)
 		}
-		// 压缩器
-		compressor := rpcmsg.Compressor[client.option.CompressType]
-		payload, err := compressor.Compress(encodeRes)
-		if err != nil {
-			log.Printf("compress err:%+v\n", err)
-			return errorHandler(err)
+		// ctx没有设置超时时间，使用 ReadTimeout 作为默认的响应超时时间
+		if _, ok := callCtx.Deadline(); !ok && client.option.ReadTimeout > 0 {
+			var cancel context.CancelFunc
+			callCtx, cancel = context.WithTimeout(callCtx, client.option.ReadTimeout)
+			defer cancel()
 		}
 
-		waitMsg := newWaitMsg()
-		// 发送请求
-		conf := rpcmsg.RPCMsgConfig{
-			MsgTypeConf:       rpcmsg.Request,
-			CompressTypeConf:  client.option.CompressType,
-			SerializeTypeConf: client.option.SerializeType,
-			VersionConf:       client.option.Version,
-			ObjectName:        serviceInfo[0],
-			MethodName:        serviceInfo[1],
-			Seq:               waitMsg.GetSeq(),
-		}
-		// 设置读取超时时间
-		if client.option.WriteTimeout != 0 {
-			client.conn.SetWriteDeadline(time.Now().Add(client.option.WriteTimeout))
-		}
-		client.addWaitMsg(waitMsg)
-		client.mutex.Lock()
-		err = rpcmsg.SendTo(client.conn, payload, conf)
-		client.mutex.Unlock()
-		if err != nil {
-			client.removeWaitMsg(waitMsg.GetSeq())
-			return errorHandler(err)
-		}
-
-		//log.Println("send to server success")
-
-		// 获取请求
-
-		resMsg := waitMsg.Wait()
-		if resMsg == nil {
-			return errorHandler(ErrServer)
-		}
-		// 解压缩
-		compressRes, err := compressor.UnCompress(resMsg.Payload)
-		if err != nil {
-			return errorHandler(err)
+		// 入参
+		argsIn := make([]interface{}, 0, len(args))
+		for _, arg := range args {
+			argsIn = append(argsIn, arg.Interface())
 		}
-
-		argsOut := make([]interface{}, 0)
-		// 反序列化
-		err = codeTool.Decode(compressRes, &argsOut)
+		out, err := invoker(callCtx, servicePath, argsIn)
 		if err != nil {
 			return errorHandler(err)
 		}
-		//log.Println("decode success")
-
-		// 出参
-		if len(argsOut) != numOut {
-			argsOut = make([]interface{}, numOut)
-			log.Println("出参个数和实际需要不一致")
+		// 拦截器可能修改了结果，检查个数和类型
+		if len(out) != len(outTypes) {
+			return errorHandler(fmt.Errorf("%w: needs %d results, got %d", rpcmsg.ErrArgsMismatch, len(outTypes), len(out)))
 		}
-		// 利用 argsOut填充result
-		results = make([]reflect.Value, numOut)
-
-		for i := 0; i < len(results); i++ {
-			if argsOut[i] == nil {
-				results[i] = reflect.Zero(funcValue.Type().Out(i))
-			} else {
-				results[i] = reflect.ValueOf(argsOut[i])
+		results = make([]reflect.Value, 0, numOut)
+		for i, res := range out {
+			if res == nil {
+				results = append(results, reflect.Zero(outTypes[i]))
+				continue
 			}
+			value := reflect.ValueOf(res)
+			if !value.Type().AssignableTo(outTypes[i]) {
+				return errorHandler(fmt.Errorf("%w: result %d needs %s, got %s", rpcmsg.ErrArgsMismatch, i, outTypes[i], value.Type()))
+			}
+			results = append(results, value)
 		}
-
-		return results
+		return append(results, reflect.Zero(funcValue.Type().Out(numOut-1)))
 	}
 	// 相当于修改了stub指向的函数为fn
 	funcValue.Set(reflect.MakeFunc(funcValue.Type(), fn))
 
 	// 执行 funcValue函数
 
+	if withCtx && len(params) == funcValue.Type().NumIn()-1 {
+		params = append([]interface{}{ctx}, params...)
+	}
 	if len(params) != funcValue.Type().NumIn() {
 		return nil, ErrParam
 	}
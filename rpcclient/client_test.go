@@ -0,0 +1,1018 @@
+package rpcclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gofish2020/easyrpc/compress"
+	"github.com/gofish2020/easyrpc/metadata"
+	"github.com/gofish2020/easyrpc/registry"
+	"github.com/gofish2020/easyrpc/rpcmsg"
+	"github.com/gofish2020/easyrpc/rpcserver"
+	"github.com/stretchr/testify/assert"
+)
+
+// newPipeClient 客户端使用 net.Pipe 的一端，另一端交给测试模拟服务端
+func newPipeClient(option Option) (*RPCClient, net.Conn) {
+	clientConn, serverConn := net.Pipe()
+	client := NewRPCClient(option)
+	ep := newEndpoint("pipe", option, nil)
+	ep.conns[0] = newRPCConn("pipe", clientConn, option, ep.connLost)
+	ep.setState(Ready)
+	client.static = newResolver(option)
+	client.static.endpoints = []*endpoint{ep}
+	return client, serverConn
+}
+
+// slowPeer 读取请求，延迟 delay 后返回 results（delay<0 表示永不响应）
+func slowPeer(t *testing.T, conn net.Conn, delay time.Duration, results ...interface{}) {
+	for {
+		msg, err := rpcmsg.RecvFrom(conn)
+		if err != nil {
+			return
+		}
+		if delay < 0 {
+			continue
+		}
+		go func(msg *rpcmsg.RPCMsg) {
+			time.Sleep(delay)
+			payload, err := rpcmsg.EncodeArgs(rpcmsg.LookupCodec(msg.SerializeType()), results)
+			assert.Equal(t, nil, err)
+			payload, err = rpcmsg.LookupCompressor(msg.CompressType()).Compress(payload)
+			assert.Equal(t, nil, err)
+			rpcmsg.SendTo(conn, payload, rpcmsg.RPCMsgConfig{
+				MsgTypeConf:       rpcmsg.Response,
+				CompressTypeConf:  msg.CompressType(),
+				SerializeTypeConf: msg.SerializeType(),
+				VersionConf:       msg.Version(),
+				Seq:               msg.Seq,
+			})
+		}(msg)
+	}
+}
+
+func TestReadTimeoutAsDefaultDeadline(t *testing.T) {
+	option := DefaultOption
+	option.FailMode = Failfast
+	option.ReadTimeout = 50 * time.Millisecond
+	client, serverConn := newPipeClient(option)
+	defer client.Close()
+	go slowPeer(t, serverConn, -1)
+
+	var sayHello func(s string) (string, error)
+	start := time.Now()
+	_, err := client.Call(context.Background(), "User.SayHello", &sayHello, "hello")
+	assert.Equal(t, nil, err)
+
+	_, err = sayHello("hello")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+
+	// 超时的请求已经从等待队列中移除
+	c := client.static.endpoints[0].conns[0]
+	c.mu.RLock()
+	assert.Equal(t, 0, len(c.waiting))
+	c.mu.RUnlock()
+}
+
+func TestContextCanceled(t *testing.T) {
+	option := DefaultOption
+	option.FailMode = Failfast
+	option.ReadTimeout = 500 * time.Millisecond
+	option.Breaker = &BreakerOption{ConsecutiveFailures: 1, OpenTimeout: time.Minute}
+	client, serverConn := newPipeClient(option)
+	defer client.Close()
+	go slowPeer(t, serverConn, -1)
+
+	var sayHello func(ctx context.Context, s string) (string, error)
+	_, err := client.Call(context.Background(), "User.SayHello", &sayHello, "hello")
+	assert.Equal(t, nil, err)
+
+	// 半开状态只有一个试探名额，取消的请求需要归还
+	b := client.static.endpoints[0].breaker
+	b.mu.Lock()
+	b.setState(BreakerHalfOpen)
+	b.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	_, err = sayHello(ctx, "hello")
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+
+	// 取消的请求已经从等待队列中移除
+	c := client.static.endpoints[0].conns[0]
+	c.mu.RLock()
+	assert.Equal(t, 0, len(c.waiting))
+	c.mu.RUnlock()
+
+	// 取消不算失败，试探名额已经归还
+	b.mu.Lock()
+	assert.Equal(t, BreakerHalfOpen, b.state)
+	assert.Equal(t, 0, b.probes)
+	b.mu.Unlock()
+}
+
+func TestContextDeadlineOverridesReadTimeout(t *testing.T) {
+	option := DefaultOption
+	option.FailMode = Failfast
+	option.ReadTimeout = 50 * time.Millisecond
+	client, serverConn := newPipeClient(option)
+	defer client.Close()
+	go slowPeer(t, serverConn, 150*time.Millisecond, "hello")
+
+	var sayHello func(ctx context.Context, s string) (string, error)
+	_, err := client.Call(context.Background(), "User.SayHello", &sayHello, "hello")
+	assert.Equal(t, nil, err)
+
+	// 没有超时时间：ReadTimeout 生效
+	_, err = sayHello(context.Background(), "hello")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// ctx 的超时时间大于服务端响应时间
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	res, err := sayHello(ctx, "hello")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "hello", res)
+}
+
+var errUserNotExist = errors.New("user not exist")
+
+func init() {
+	rpcmsg.RegisterError(rpcmsg.CodeUserBase+1, errUserNotExist)
+
+	dialTimeout = func(network, addr string, timeout time.Duration) (net.Conn, error) {
+		if v, ok := blackholes.Load(addr); ok {
+			hole := v.(*blackhole)
+			hole.dialing <- struct{}{}
+			select {
+			case <-hole.release:
+			case <-time.After(timeout):
+			}
+			return nil, fmt.Errorf("dial %s %s: i/o timeout", network, addr)
+		}
+		return net.DialTimeout(network, addr, timeout)
+	}
+}
+
+// blackholes 模拟没有响应的地址：建立连接时阻塞，直到 ConnectTimeout 或者测试结束
+var blackholes sync.Map
+
+type blackhole struct {
+	dialing chan struct{} // 每次开始建立连接时发送
+	release chan struct{}
+}
+
+func newBlackhole(t *testing.T, addr string) *blackhole {
+	hole := &blackhole{dialing: make(chan struct{}, 64), release: make(chan struct{})}
+	blackholes.Store(addr, hole)
+	t.Cleanup(func() {
+		blackholes.Delete(addr)
+		close(hole.release)
+	})
+	return hole
+}
+
+func TestRemoteError(t *testing.T) {
+	client, serverConn := newPipeClient(DefaultOption)
+	defer client.Close()
+	go func() {
+		for {
+			msg, err := rpcmsg.RecvFrom(serverConn)
+			if err != nil {
+				return
+			}
+			rpcmsg.SendTo(serverConn, nil, rpcmsg.RPCMsgConfig{
+				MsgTypeConf: rpcmsg.Response,
+				Seq:         msg.Seq,
+				Error:       rpcmsg.ToError(fmt.Errorf("id %d: %w", 100, errUserNotExist)),
+			})
+		}
+	}()
+
+	var getUserName func(id int) (string, error)
+	_, err := client.Call(context.Background(), "User.GetUserName", &getUserName, 100)
+	assert.Equal(t, nil, err)
+
+	name, err := getUserName(100)
+	assert.Equal(t, "", name)
+	assert.ErrorIs(t, err, errUserNotExist)
+
+	var remoteErr *RemoteError
+	assert.True(t, errors.As(err, &remoteErr))
+	assert.Equal(t, rpcmsg.CodeUserBase+1, remoteErr.Code)
+	assert.Equal(t, "id 100: user not exist", remoteErr.Message)
+}
+
+// fakeServer 监听随机端口，handle 处理每个连接
+func fakeServer(t *testing.T, handle func(conn net.Conn)) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, nil, err)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go handle(conn)
+		}
+	}()
+	return l
+}
+
+func reply(conn net.Conn, msg *rpcmsg.RPCMsg, results ...interface{}) error {
+	payload, err := rpcmsg.EncodeArgs(rpcmsg.LookupCodec(msg.SerializeType()), results)
+	if err != nil {
+		return err
+	}
+	payload, err = rpcmsg.LookupCompressor(msg.CompressType()).Compress(payload)
+	if err != nil {
+		return err
+	}
+	return rpcmsg.SendTo(conn, payload, rpcmsg.RPCMsgConfig{
+		MsgTypeConf:       rpcmsg.Response,
+		CompressTypeConf:  msg.CompressType(),
+		SerializeTypeConf: msg.SerializeType(),
+		VersionConf:       msg.Version(),
+		Seq:               msg.Seq,
+	})
+}
+
+// droppingServer 前 drop 个请求关闭连接不返回结果，之后返回 name，requests 记录收到的请求个数
+func droppingServer(t *testing.T, name string, drop int32) (l net.Listener, requests *int32) {
+	requests = new(int32)
+	l = fakeServer(t, func(conn net.Conn) {
+		defer conn.Close()
+		for {
+			msg, err := rpcmsg.RecvFrom(conn)
+			if err != nil {
+				return
+			}
+			if atomic.AddInt32(requests, 1) <= drop {
+				return
+			}
+			reply(conn, msg, name)
+		}
+	})
+	return l, requests
+}
+
+type retryRecord struct {
+	addr    string
+	attempt int
+}
+
+func TestFailMode(t *testing.T) {
+	newClient := func(mode FailMode, addrs ...string) (*RPCClient, *[]retryRecord) {
+		records := &[]retryRecord{}
+		option := DefaultOption
+		option.FailMode = mode
+		option.Retries = 3
+		option.RetryHook = func(servicePath, addr string, attempt int, err error) {
+			assert.Equal(t, "Server.Who", servicePath)
+			assert.NotNil(t, err)
+			*records = append(*records, retryRecord{addr, attempt})
+		}
+		client := NewRPCClient(option)
+		assert.Equal(t, nil, client.Connect(addrs...))
+		return client, records
+	}
+	// call 只请求一次（Call 会执行一次 stub）
+	call := func(client *RPCClient) (string, error) {
+		var who func() (string, error)
+		res, err := client.Call(context.Background(), "Server.Who", &who)
+		assert.Equal(t, nil, err)
+		results := res.([]reflect.Value)
+		err, _ = results[1].Interface().(error)
+		return results[0].String(), err
+	}
+
+	// Failretry：在同一个地址重试
+	server, requests := droppingServer(t, "A", 2)
+	defer server.Close()
+	addr := server.Addr().String()
+	client, records := newClient(Failretry, addr)
+	res, err := call(client)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "A", res)
+	assert.Equal(t, int32(3), atomic.LoadInt32(requests))
+	assert.Equal(t, []retryRecord{{addr, 1}, {addr, 2}}, *records)
+	client.Close()
+
+	// Failretry：重试 Retries 次后失败
+	server, requests = droppingServer(t, "A", 100)
+	defer server.Close()
+	addr = server.Addr().String()
+	client, records = newClient(Failretry, addr)
+	_, err = call(client)
+	assert.ErrorIs(t, err, ErrServer)
+	assert.Equal(t, int32(4), atomic.LoadInt32(requests))
+	assert.Equal(t, []retryRecord{{addr, 1}, {addr, 2}, {addr, 3}}, *records)
+	client.Close()
+
+	// Failfast：只请求一次
+	server, requests = droppingServer(t, "A", 1)
+	defer server.Close()
+	client, records = newClient(Failfast, server.Addr().String())
+	_, err = call(client)
+	assert.ErrorIs(t, err, ErrServer)
+	assert.Equal(t, int32(1), atomic.LoadInt32(requests))
+	assert.Equal(t, 0, len(*records))
+	client.Close()
+
+	// Failover：切换到下一个地址重试
+	serverA, requestsA := droppingServer(t, "A", 100)
+	defer serverA.Close()
+	serverB, requestsB := droppingServer(t, "B", 0)
+	defer serverB.Close()
+	client, records = newClient(Failover, serverA.Addr().String(), serverB.Addr().String())
+	defer client.Close()
+	res, err = call(client)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "B", res)
+	assert.Equal(t, int32(1), atomic.LoadInt32(requestsA))
+	assert.Equal(t, int32(1), atomic.LoadInt32(requestsB))
+	assert.Equal(t, []retryRecord{{serverA.Addr().String(), 1}}, *records)
+}
+
+func TestGoAway(t *testing.T) {
+	// 服务A：第二个请求到达时通知GoAway，延迟返回结果，然后停止服务
+	var serverA net.Listener
+	serverA = fakeServer(t, func(conn net.Conn) {
+		defer conn.Close()
+		msg, err := rpcmsg.RecvFrom(conn)
+		if err != nil {
+			return
+		}
+		reply(conn, msg, "A")
+
+		msg, err = rpcmsg.RecvFrom(conn)
+		if err != nil {
+			return
+		}
+		serverA.Close()
+		rpcmsg.SendTo(conn, nil, rpcmsg.RPCMsgConfig{MsgTypeConf: rpcmsg.GoAway})
+		time.Sleep(100 * time.Millisecond)
+		reply(conn, msg, "A")
+		// 连接由客户端在请求完成后关闭
+		_, err = rpcmsg.RecvFrom(conn)
+		assert.Equal(t, io.EOF, err)
+	})
+	serverB := fakeServer(t, func(conn net.Conn) {
+		defer conn.Close()
+		for {
+			msg, err := rpcmsg.RecvFrom(conn)
+			if err != nil {
+				return
+			}
+			reply(conn, msg, "B")
+		}
+	})
+	defer serverB.Close()
+
+	option := DefaultOption
+	option.FailMode = Failfast
+	client := NewRPCClient(option)
+	err := client.Connect(serverA.Addr().String(), serverB.Addr().String())
+	assert.Equal(t, nil, err)
+	defer client.Close()
+
+	var who func() (string, error)
+	_, err = client.Call(context.Background(), "Server.Who", &who)
+	assert.Equal(t, nil, err)
+
+	// 等待中的请求在旧的连接上完成
+	done := make(chan string)
+	go func() {
+		res, err := who()
+		assert.Equal(t, nil, err)
+		done <- res
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// 新的请求自动切换到服务B
+	res, err := who()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "B", res)
+	assert.Equal(t, "A", <-done)
+}
+
+type whoService struct {
+	name string
+}
+
+func (s *whoService) Who() (string, error) {
+	return s.name, nil
+}
+
+func (s *whoService) Slow() (string, error) {
+	time.Sleep(300 * time.Millisecond)
+	return s.name, nil
+}
+
+// goAwayFilter 转发到 target 的代理，丢弃服务端发送的 GoAway（模拟请求和 GoAway 同时发送）
+func goAwayFilter(t *testing.T, target string) net.Listener {
+	return fakeServer(t, func(conn net.Conn) {
+		defer conn.Close()
+		upstream, err := net.Dial("tcp", target)
+		if err != nil {
+			return
+		}
+		defer upstream.Close()
+		go func() {
+			io.Copy(upstream, conn)
+			upstream.Close()
+		}()
+		reader := rpcmsg.NewMsgReader(upstream, 0)
+		for {
+			msg, err := reader.Recv()
+			if err != nil {
+				return
+			}
+			if msg.MsgType() == rpcmsg.GoAway {
+				continue
+			}
+			if err := msg.SendMsg(conn); err != nil {
+				return
+			}
+		}
+	})
+}
+
+func TestGoAwayUnavailable(t *testing.T) {
+	// 服务A：真实的服务端，关闭中收到的请求返回 Unavailable
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, nil, err)
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+	serverOption := rpcserver.DefaultOption
+	serverOption.Ip = "127.0.0.1"
+	serverOption.Port = port
+	serverA := rpcserver.NewRPCServer(serverOption)
+	assert.Equal(t, nil, serverA.RegisterByName("Server", &whoService{name: "A"}))
+	serverA.Run()
+	proxy := goAwayFilter(t, l.Addr().String())
+	defer proxy.Close()
+
+	serverB, _ := droppingServer(t, "B", 0)
+	defer serverB.Close()
+
+	option := DefaultOption
+	option.FailMode = Failfast
+	client := NewRPCClient(option)
+	assert.Equal(t, nil, client.Connect(proxy.Addr().String(), serverB.Addr().String()))
+	defer client.Close()
+
+	var who, slow func() (string, error)
+	res, err := client.Call(context.Background(), "Server.Who", &who)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "A", res.([]reflect.Value)[0].String())
+	_, err = client.Call(context.Background(), "Server.Slow", &slow)
+	assert.Equal(t, nil, err)
+
+	// 处理中的请求使服务A的连接保持打开
+	done := make(chan string)
+	go func() {
+		res, err := slow()
+		assert.Equal(t, nil, err)
+		done <- res
+	}()
+	time.Sleep(50 * time.Millisecond)
+	go serverA.Shutdown(context.Background())
+	time.Sleep(50 * time.Millisecond)
+
+	// 客户端没有收到 GoAway，请求被服务A拒绝后切换到服务B（Failfast 也不算失败）
+	res2, err := who()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "B", res2)
+	assert.Equal(t, "A", <-done)
+}
+
+func TestReconnect(t *testing.T) {
+	// 服务端：返回固定结果，stop 关闭监听和所有连接（模拟服务重启）
+	serve := func(addr string) (stop func()) {
+		l, err := net.Listen("tcp", addr)
+		if !assert.Equal(t, nil, err) {
+			t.FailNow()
+		}
+		conns := make(chan net.Conn, 16)
+		go func() {
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					return
+				}
+				conns <- conn
+				go func() {
+					for {
+						msg, err := rpcmsg.RecvFrom(conn)
+						if err != nil {
+							return
+						}
+						reply(conn, msg, "ok")
+					}
+				}()
+			}
+		}()
+		return func() {
+			l.Close()
+			for {
+				select {
+				case conn := <-conns:
+					conn.Close()
+				default:
+					return
+				}
+			}
+		}
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, nil, err)
+	addr := l.Addr().String()
+	l.Close()
+	stop := serve(addr)
+
+	states := make(chan ConnState, 64)
+	option := DefaultOption
+	option.FailMode = Failfast
+	option.Reconnect = true
+	option.BackoffBase = 20 * time.Millisecond
+	option.BackoffMax = 50 * time.Millisecond
+	fastClient := NewRPCClient(option)
+	assert.Equal(t, nil, fastClient.Connect(addr))
+	defer fastClient.Close()
+
+	option.WaitForReady = true
+	option.StateHook = func(a string, state ConnState) {
+		assert.Equal(t, addr, a)
+		states <- state
+	}
+	client := NewRPCClient(option)
+	assert.Equal(t, nil, client.Connect(addr))
+
+	var fastPing, ping func(ctx context.Context) (string, error)
+	_, err = fastClient.Call(context.Background(), "Server.Ping", &fastPing)
+	assert.Equal(t, nil, err)
+	_, err = client.Call(context.Background(), "Server.Ping", &ping)
+	assert.Equal(t, nil, err)
+
+	// 服务停止：不等待重连的请求立即失败
+	stop()
+	time.Sleep(100 * time.Millisecond)
+	_, err = fastPing(context.Background())
+	assert.ErrorIs(t, err, ErrServer)
+
+	// 等待重连的请求，在服务重启后完成
+	done := make(chan error)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		res, err := ping(ctx)
+		assert.Equal(t, "ok", res)
+		done <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+	stop = serve(addr)
+	defer stop()
+	assert.Equal(t, nil, <-done)
+
+	client.Close()
+	close(states)
+	seen := make([]ConnState, 0)
+	for state := range states {
+		if len(seen) == 0 || seen[len(seen)-1] != state {
+			seen = append(seen, state)
+		}
+	}
+	assert.Equal(t, []ConnState{Connecting, Ready}, seen[:2])
+	assert.Equal(t, TransientFailure, seen[2])
+	assert.Equal(t, []ConnState{Connecting, Ready, Shutdown}, seen[len(seen)-3:])
+}
+
+func TestConnectFailStopsReconnect(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, nil, err)
+	addr := l.Addr().String()
+	l.Close()
+
+	var transitions int32
+	option := DefaultOption
+	option.Reconnect = true
+	option.BackoffBase = 10 * time.Millisecond
+	option.BackoffMax = 10 * time.Millisecond
+	option.StateHook = func(addr string, state ConnState) {
+		atomic.AddInt32(&transitions, 1)
+	}
+	client := NewRPCClient(option)
+	assert.NotNil(t, client.Connect(addr))
+
+	// 连接失败后不再后台重连
+	n := atomic.LoadInt32(&transitions)
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, n, atomic.LoadInt32(&transitions))
+
+	// 服务启动后重新 Connect 可以使用
+	server, _ := droppingServer(t, "A", 0)
+	defer server.Close()
+	assert.Equal(t, nil, client.Connect(server.Addr().String()))
+	defer client.Close()
+	var who func() (string, error)
+	res, err := client.Call(context.Background(), "Server.Who", &who)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "A", res.([]reflect.Value)[0].String())
+}
+
+func TestDialWithoutLock(t *testing.T) {
+	addr := "10.255.255.1:8972"
+	hole := newBlackhole(t, addr)
+	ep := newEndpoint(addr, DefaultOption, map[string]string{WeightKey: "1"})
+	defer ep.close()
+
+	go ep.getConn(context.Background(), true)
+	<-hole.dialing
+
+	// 建立连接时不阻塞 endpoint 的其他方法
+	done := make(chan struct{})
+	go func() {
+		ep.Active()
+		ep.Metadata()
+		ep.connLost(&rpcConn{})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("blocked by dial")
+	}
+
+	// 等待建立连接的请求按照ctx的超时时间返回
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := ep.getConn(ctx, false)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestBlackholeInstance(t *testing.T) {
+	server := fakeServer(t, func(conn net.Conn) {
+		defer conn.Close()
+		for {
+			msg, err := rpcmsg.RecvFrom(conn)
+			if err != nil {
+				return
+			}
+			reply(conn, msg, "A")
+		}
+	})
+	defer server.Close()
+	addr := "10.255.255.1:8973"
+	hole := newBlackhole(t, addr)
+
+	reg := registry.NewMemoryRegistry()
+	assert.Equal(t, nil, reg.Register(registry.Instance{Service: "Server", Addr: server.Addr().String()}))
+	assert.Equal(t, nil, reg.Register(registry.Instance{Service: "Server", Addr: addr}))
+	option := DefaultOption
+	option.Registry = reg
+	option.FailMode = Failfast
+	option.SelectMode = LeastActive
+	option.PoolSize = 3
+	client := NewRPCClient(option)
+	defer client.Close()
+
+	// 连接池的所有位置同时建立连接
+	ep := newEndpoint(addr, option, nil)
+	defer ep.close()
+	go ep.getConn(context.Background(), true)
+	for i := 0; i < option.PoolSize; i++ {
+		select {
+		case <-hole.dialing:
+		case <-time.After(time.Second):
+			t.Fatal("pool slots are not dialed in parallel")
+		}
+	}
+
+	var who func(ctx context.Context) (string, error)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	client.Call(ctx, "Server.Who", &who)
+
+	// 没有响应的地址不影响其他地址的请求，选择到它的请求按照ctx的超时时间返回
+	start := time.Now()
+	var wg sync.WaitGroup
+	var success int32
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+			if res, err := who(ctx); err == nil && res == "A" {
+				atomic.AddInt32(&success, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Greater(t, atomic.LoadInt32(&success), int32(0))
+}
+
+func TestConnPool(t *testing.T) {
+	// 服务端：每个连接返回自己的编号，Slow 请求延迟返回
+	var connID int32
+	server := fakeServer(t, func(conn net.Conn) {
+		defer conn.Close()
+		id := int(atomic.AddInt32(&connID, 1))
+		var wmu sync.Mutex
+		for {
+			msg, err := rpcmsg.RecvFrom(conn)
+			if err != nil {
+				return
+			}
+			go func(msg *rpcmsg.RPCMsg) {
+				if msg.MethodName == "Slow" {
+					time.Sleep(200 * time.Millisecond)
+				}
+				wmu.Lock()
+				defer wmu.Unlock()
+				reply(conn, msg, id)
+			}(msg)
+		}
+	})
+	defer server.Close()
+
+	newClient := func(strategy PoolStrategy) *RPCClient {
+		option := DefaultOption
+		option.PoolSize = 3
+		option.PoolStrategy = strategy
+		client := NewRPCClient(option)
+		assert.Equal(t, nil, client.Connect(server.Addr().String()))
+		return client
+	}
+
+	// 轮询：请求平均分配到每个连接
+	client := newClient(PoolRoundRobin)
+	var who func() (int, error)
+	res, err := client.Call(context.Background(), "Server.Who", &who)
+	assert.Equal(t, nil, err)
+	counts := map[int]int{int(res.([]reflect.Value)[0].Int()): 1}
+	for i := 0; i < 5; i++ {
+		id, err := who()
+		assert.Equal(t, nil, err)
+		counts[id]++
+	}
+	assert.Equal(t, 3, len(counts))
+	for _, n := range counts {
+		assert.Equal(t, 2, n)
+	}
+	client.Close()
+
+	// 最少请求：慢请求占用的连接不再被选择
+	client = newClient(PoolLeastInflight)
+	defer client.Close()
+	var slow func() (int, error)
+	_, err = client.Call(context.Background(), "Server.Slow", &slow)
+	assert.Equal(t, nil, err)
+	_, err = client.Call(context.Background(), "Server.Who", &who)
+	assert.Equal(t, nil, err)
+
+	busy := make(chan int)
+	go func() {
+		id, err := slow()
+		assert.Equal(t, nil, err)
+		busy <- id
+	}()
+	time.Sleep(50 * time.Millisecond)
+	ids := make(map[int]bool)
+	for i := 0; i < 3; i++ {
+		id, err := who()
+		assert.Equal(t, nil, err)
+		ids[id] = true
+	}
+	busyID := <-busy
+	assert.False(t, ids[busyID])
+}
+
+func TestRegistry(t *testing.T) {
+	newServer := func(name string) net.Listener {
+		return fakeServer(t, func(conn net.Conn) {
+			defer conn.Close()
+			for {
+				msg, err := rpcmsg.RecvFrom(conn)
+				if err != nil {
+					return
+				}
+				reply(conn, msg, name)
+			}
+		})
+	}
+	serverA, serverB := newServer("A"), newServer("B")
+	defer serverA.Close()
+	defer serverB.Close()
+	a := registry.Instance{Service: "Server", Addr: serverA.Addr().String()}
+	b := registry.Instance{Service: "Server", Addr: serverB.Addr().String()}
+
+	reg := registry.NewMemoryRegistry()
+	option := DefaultOption
+	option.Registry = reg
+	client := NewRPCClient(option)
+	defer client.Close()
+
+	// 服务没有实例
+	var who func() (string, error)
+	_, err := client.Call(context.Background(), "Server.Who", &who)
+	assert.Equal(t, nil, err)
+	_, err = who()
+	assert.ErrorIs(t, err, ErrNoInstance)
+
+	assert.Equal(t, nil, reg.Register(a))
+	assert.Eventually(t, func() bool {
+		res, err := who()
+		return err == nil && res == "A"
+	}, time.Second, 10*time.Millisecond)
+
+	// 实例变化后，不需要重启客户端
+	assert.Equal(t, nil, reg.Register(b))
+	assert.Equal(t, nil, reg.Deregister(a))
+	assert.Eventually(t, func() bool {
+		res, err := who()
+		return err == nil && res == "B"
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestInterceptors(t *testing.T) {
+	var order []string
+	trace := func(name string) Interceptor {
+		return func(ctx context.Context, servicePath string, args []interface{}, next Invoker) ([]interface{}, error) {
+			order = append(order, name+" before "+servicePath)
+			results, err := next(ctx, servicePath, args)
+			order = append(order, name+" after")
+			return results, err
+		}
+	}
+	// 缓存：命中时不发送请求
+	cache := func(ctx context.Context, servicePath string, args []interface{}, next Invoker) ([]interface{}, error) {
+		if args[0] == "cached" {
+			return []interface{}{"from cache"}, nil
+		}
+		return next(ctx, servicePath, args)
+	}
+	option := DefaultOption
+	option.Interceptors = []Interceptor{trace("first"), trace("second"), cache}
+	client, serverConn := newPipeClient(option)
+	defer client.Close()
+	go slowPeer(t, serverConn, 0, "from server")
+
+	var sayHello func(s string) (string, error)
+	_, err := client.Call(context.Background(), "User.SayHello", &sayHello, "hello")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"first before User.SayHello", "second before User.SayHello", "second after", "first after"}, order)
+
+	res, err := sayHello("hello")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "from server", res)
+	res, err = sayHello("cached")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "from cache", res)
+
+	// 拦截器返回的结果类型和 stub 不一致
+	option.Interceptors = []Interceptor{func(ctx context.Context, servicePath string, args []interface{}, next Invoker) ([]interface{}, error) {
+		return []interface{}{1}, nil
+	}}
+	client, _ = newPipeClient(option)
+	defer client.Close()
+	_, err = client.Call(context.Background(), "User.SayHello", &sayHello, "hello")
+	assert.Equal(t, nil, err)
+	_, err = sayHello("hello")
+	assert.ErrorIs(t, err, rpcmsg.ErrArgsMismatch)
+}
+
+func TestMetadata(t *testing.T) {
+	// 没有从 DefaultOption 修改的配置（协议版本为0）也发送 metadata
+	for _, option := range []Option{DefaultOption, {ReadTimeout: time.Second}} {
+		testMetadata(t, option)
+	}
+}
+
+func testMetadata(t *testing.T, option Option) {
+	client, serverConn := newPipeClient(option)
+	defer client.Close()
+	// 服务端把请求的 metadata 作为 trailer 返回
+	go func() {
+		for {
+			msg, err := rpcmsg.RecvFrom(serverConn)
+			if err != nil {
+				return
+			}
+			payload, _ := rpcmsg.EncodeArgs(rpcmsg.LookupCodec(msg.SerializeType()), []interface{}{"ok"})
+			payload, _ = rpcmsg.LookupCompressor(msg.CompressType()).Compress(payload)
+			rpcmsg.SendTo(serverConn, payload, rpcmsg.RPCMsgConfig{
+				MsgTypeConf:       rpcmsg.Response,
+				CompressTypeConf:  msg.CompressType(),
+				SerializeTypeConf: msg.SerializeType(),
+				VersionConf:       msg.Version(),
+				Seq:               msg.Seq,
+				Metadata:          msg.Metadata,
+			})
+		}
+	}()
+
+	ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("trace-id", "abc"))
+	ctx = metadata.AppendToOutgoingContext(ctx, "user", "nash")
+	var trailer metadata.MD
+	ctx = metadata.WithTrailer(ctx, &trailer)
+
+	var sayHello func(s string) (string, error)
+	_, err := client.Call(ctx, "User.SayHello", &sayHello, "hello")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, metadata.MD{"trace-id": "abc", "user": "nash"}, trailer)
+}
+
+// countCompressor 记录压缩的次数
+type countCompressor struct {
+	compress.Compression
+	count int32
+}
+
+func (c *countCompressor) Compress(data []byte) ([]byte, error) {
+	atomic.AddInt32(&c.count, 1)
+	return c.Compression.Compress(data)
+}
+
+func TestCompressThreshold(t *testing.T) {
+	// echo 服务端记录请求的压缩类型，不压缩原样返回参数
+	echo := func(serverConn net.Conn, compressTypes chan<- rpcmsg.CompressType) {
+		for {
+			msg, err := rpcmsg.RecvFrom(serverConn)
+			if err != nil {
+				return
+			}
+			compressTypes <- msg.CompressType()
+			payload, err := rpcmsg.UnCompress(msg.CompressType(), msg.Payload)
+			assert.Equal(t, nil, err)
+			rpcmsg.SendTo(serverConn, payload, rpcmsg.RPCMsgConfig{
+				MsgTypeConf:       rpcmsg.Response,
+				CompressTypeConf:  rpcmsg.None,
+				SerializeTypeConf: msg.SerializeType(),
+				VersionConf:       msg.Version(),
+				Seq:               msg.Seq,
+			})
+		}
+	}
+
+	option := DefaultOption
+	option.CompressType = rpcmsg.Snappy
+	option.CompressThreshold = 64
+	client, serverConn := newPipeClient(option)
+	defer client.Close()
+	compressTypes := make(chan rpcmsg.CompressType, 1)
+	go echo(serverConn, compressTypes)
+
+	var sayHello func(s string) (string, error)
+	_, err := client.Call(context.Background(), "User.SayHello", &sayHello, "hello")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, rpcmsg.None, <-compressTypes)
+
+	large := strings.Repeat("hello", 100)
+	res, err := sayHello(large)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, large, res)
+	assert.Equal(t, rpcmsg.Snappy, <-compressTypes)
+
+	// 替换压缩器（设置压缩级别），服务端使用注册的压缩器解压缩
+	snappy := &countCompressor{Compression: compress.GetSnappyCompresser(compress.WithLevel(3))}
+	levelOption := option
+	levelOption.Compressors = map[rpcmsg.CompressType]compress.Compression{rpcmsg.Snappy: snappy}
+	client3, serverConn3 := newPipeClient(levelOption)
+	defer client3.Close()
+	go echo(serverConn3, compressTypes)
+	_, err = client3.Call(context.Background(), "User.SayHello", &sayHello, large)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, rpcmsg.Snappy, <-compressTypes)
+	res, err = sayHello(large)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, large, res)
+	assert.Equal(t, rpcmsg.Snappy, <-compressTypes)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&snappy.count))
+
+	// 不支持的压缩类型返回错误
+	option.CompressType = rpcmsg.CompressType(100)
+	client2, _ := newPipeClient(option)
+	defer client2.Close()
+	_, err = client2.Call(context.Background(), "User.SayHello", &sayHello, large)
+	assert.Equal(t, nil, err)
+	_, err = sayHello(large)
+	assert.NotNil(t, err)
+}
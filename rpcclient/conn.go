@@ -0,0 +1,184 @@
+package rpcclient
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofish2020/easyrpc/rpcmsg"
+)
+
+// rpcConn 客户端到某个服务地址的一个连接
+type rpcConn struct {
+	addr   string
+	conn   net.Conn
+	option Option
+
+	mutex sync.Mutex // 发送的并发控制
+
+	mu      sync.RWMutex // map的并发控制
+	waiting map[int64]*waitMsg
+
+	closed   int32 // 连接是否已断开
+	draining int32 // 服务端即将关闭（收到GoAway），不再发送新的请求
+
+	onLost func(*rpcConn) // 收到GoAway或者连接断开时通知，可以为nil
+}
+
+func newRPCConn(addr string, conn net.Conn, option Option, onLost func(*rpcConn)) *rpcConn {
+	c := &rpcConn{
+		addr:    addr,
+		conn:    conn,
+		option:  option,
+		waiting: make(map[int64]*waitMsg),
+		onLost:  onLost,
+	}
+	go c.loopWaitMsg()
+	return c
+}
+
+// dialTimeout 建立网络连接（测试时替换）
+var dialTimeout = net.DialTimeout
+
+func dialRPCConn(addr string, option Option, onLost func(*rpcConn)) (*rpcConn, error) {
+	conn, err := dialTimeout(option.Network, addr, option.ConnectTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return newRPCConn(addr, conn, option, onLost), nil
+}
+
+func (c *rpcConn) isClosed() bool {
+	return atomic.LoadInt32(&c.closed) == 1
+}
+
+func (c *rpcConn) isDraining() bool {
+	return atomic.LoadInt32(&c.draining) == 1
+}
+
+// addWaitMsg 连接已断开时返回 ErrServer，收到GoAway时返回 errConnDraining
+func (c *rpcConn) addWaitMsg(wMsg *waitMsg) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.isDraining() {
+		return errConnDraining
+	}
+	if c.isClosed() {
+		return ErrServer
+	}
+	c.waiting[wMsg.GetSeq()] = wMsg
+	return nil
+}
+
+func (c *rpcConn) removeWaitMsg(seq int64) *waitMsg {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	wMsg := c.waiting[seq]
+	delete(c.waiting, seq)
+	c.closeIfDrained()
+	return wMsg
+}
+
+// inflight 等待响应的请求个数
+func (c *rpcConn) inflight() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.waiting)
+}
+
+// drain 收到GoAway：不再发送新的请求，等待中的请求完成后关闭连接
+func (c *rpcConn) drain() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	atomic.CompareAndSwapInt32(&c.draining, 0, 1)
+	c.closeIfDrained()
+}
+
+func (c *rpcConn) closeIfDrained() {
+	if c.isDraining() && len(c.waiting) == 0 {
+		c.Close()
+	}
+}
+
+func (c *rpcConn) removeAllWaitMsg() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	atomic.CompareAndSwapInt32(&c.closed, 0, 1)
+	for _, waitMsg := range c.waiting {
+		waitMsg.Ready(nil)
+	}
+	c.waiting = make(map[int64]*waitMsg)
+}
+
+func (c *rpcConn) loopWaitMsg() {
+	reader := rpcmsg.NewMsgReader(c.conn, c.option.MaxFrameSize)
+	for {
+		resMsg, err := reader.Recv()
+		if err != nil {
+			break
+		}
+		if resMsg.MsgType() == rpcmsg.GoAway {
+			c.drain()
+			c.lost()
+			continue
+		}
+		wMsg := c.removeWaitMsg(resMsg.Seq)
+		if wMsg != nil { // 说明这个序列号，不存在
+			wMsg.Ready(resMsg)
+		}
+	}
+	c.removeAllWaitMsg()
+	c.lost()
+}
+
+func (c *rpcConn) lost() {
+	if c.onLost != nil {
+		c.onLost(c)
+	}
+}
+
+// call 发送请求并等待响应，连接断开时返回 ErrServer，ctx结束时返回 ctx.Err()
+func (c *rpcConn) call(ctx context.Context, payload []byte, conf rpcmsg.RPCMsgConfig) (*rpcmsg.RPCMsg, error) {
+	// 剩余的超时时间随请求发送到服务端
+	if deadline, ok := ctx.Deadline(); ok {
+		conf.Timeout = time.Until(deadline)
+		if conf.Timeout <= 0 {
+			return nil, context.DeadlineExceeded
+		}
+	}
+
+	waitMsg := newWaitMsg()
+	conf.Seq = waitMsg.GetSeq()
+
+	if err := c.addWaitMsg(waitMsg); err != nil {
+		return nil, err
+	}
+	c.mutex.Lock()
+	// 设置写超时时间
+	if c.option.WriteTimeout != 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(c.option.WriteTimeout))
+	}
+	err := rpcmsg.SendTo(c.conn, payload, conf)
+	c.mutex.Unlock()
+	if err != nil {
+		c.removeWaitMsg(waitMsg.GetSeq())
+		return nil, err
+	}
+
+	resMsg, err := waitMsg.Wait(ctx)
+	if err != nil {
+		c.removeWaitMsg(waitMsg.GetSeq())
+		return nil, err
+	}
+	if resMsg == nil {
+		return nil, ErrServer
+	}
+	return resMsg, nil
+}
+
+func (c *rpcConn) Close() {
+	atomic.CompareAndSwapInt32(&c.closed, 0, 1)
+	c.conn.Close()
+}
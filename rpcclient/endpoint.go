@@ -0,0 +1,437 @@
+package rpcclient
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ConnState 服务地址的连接状态
+type ConnState int
+
+const (
+	Idle             ConnState = iota // 还没有建立连接
+	Connecting                        // 连接中
+	Ready                             // 连接可用
+	TransientFailure                  // 连接失败/断开，等待重连
+	Shutdown                          // 客户端已关闭
+)
+
+func (state ConnState) String() string {
+	switch state {
+	case Idle:
+		return "Idle"
+	case Connecting:
+		return "Connecting"
+	case Ready:
+		return "Ready"
+	case TransientFailure:
+		return "TransientFailure"
+	case Shutdown:
+		return "Shutdown"
+	}
+	return "Unknown"
+}
+
+// StateHook 连接状态变化时回调（同步执行，不能阻塞，也不能调用 RPCClient 的方法）
+type StateHook func(addr string, state ConnState)
+
+// endpoint 一个服务地址：维护连接池和连接状态，开启 Reconnect 时断开后自动重连
+type endpoint struct {
+	addr    string
+	option  Option
+	breaker *breaker // 没有开启熔断时为nil
+
+	mu           sync.Mutex
+	metadata     map[string]string // 服务发现时的实例信息
+	conns        []*rpcConn        // 连接池，nil表示连接已断开
+	next         uint32            // PoolRoundRobin 的下一个连接
+	state        ConnState
+	ready        chan struct{} // 状态为 Ready 时关闭
+	dialing      chan struct{} // 正在建立连接，完成时关闭，没有在连接中时为nil
+	dialErr      error         // 最近一次建立连接的错误
+	goingAway    bool          // 服务端通知了GoAway，还没有重新建立连接
+	reconnecting bool
+	closed       bool
+	retired      bool          // 地址已经从服务中移除
+	done         chan struct{} // endpoint 关闭
+}
+
+func newEndpoint(addr string, option Option, metadata map[string]string) *endpoint {
+	size := option.PoolSize
+	if size <= 0 {
+		size = 1
+	}
+	return &endpoint{
+		addr:     addr,
+		option:   option,
+		breaker:  newBreaker(addr, option.Breaker),
+		metadata: metadata,
+		conns:    make([]*rpcConn, size),
+		state:    Idle,
+		ready:    make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+func (ep *endpoint) Addr() string {
+	return ep.addr
+}
+
+func (ep *endpoint) Metadata() map[string]string {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+	return ep.metadata
+}
+
+// Active 连接池中等待响应的请求个数
+func (ep *endpoint) Active() int {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+	n := 0
+	for _, c := range ep.conns {
+		if c != nil {
+			n += c.inflight()
+		}
+	}
+	return n
+}
+
+func (ep *endpoint) setMetadata(metadata map[string]string) {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+	ep.metadata = metadata
+}
+
+// setState 调用时需要持有 ep.mu
+func (ep *endpoint) setState(state ConnState) {
+	if ep.state == state {
+		return
+	}
+	if state == Ready {
+		close(ep.ready)
+	} else if ep.state == Ready {
+		ep.ready = make(chan struct{})
+	}
+	ep.state = state
+	if ep.option.StateHook != nil {
+		ep.option.StateHook(ep.addr, state)
+	}
+}
+
+func (ep *endpoint) isGoingAway() bool {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+	return ep.goingAway
+}
+
+func usable(c *rpcConn) bool {
+	return c != nil && !c.isClosed() && !c.isDraining()
+}
+
+// usableCount 可用的连接个数，调用时需要持有 ep.mu
+func (ep *endpoint) usableCount() int {
+	n := 0
+	for _, c := range ep.conns {
+		if usable(c) {
+			n++
+		}
+	}
+	return n
+}
+
+// startDial 在后台为连接池中断开的位置并行建立连接（不持有 ep.mu），已经在连接中时不重复连接，
+// 返回连接完成时关闭的 channel。调用时需要持有 ep.mu
+func (ep *endpoint) startDial() chan struct{} {
+	if ep.dialing != nil {
+		return ep.dialing
+	}
+	var slots []int
+	for i, c := range ep.conns {
+		if !usable(c) {
+			slots = append(slots, i)
+		}
+	}
+	dialing := make(chan struct{})
+	if len(slots) == 0 {
+		close(dialing)
+		return dialing
+	}
+	if len(slots) == len(ep.conns) {
+		ep.setState(Connecting)
+	}
+	ep.dialing = dialing
+	go ep.dial(slots, dialing)
+	return dialing
+}
+
+// dial 建立连接，每个连接建立后立即可用（至少有一个可用的连接时状态为 Ready）
+func (ep *endpoint) dial(slots []int, dialing chan struct{}) {
+	var wg sync.WaitGroup
+	errs := make([]error, len(slots))
+	for j, i := range slots {
+		wg.Add(1)
+		go func(j, i int) {
+			defer wg.Done()
+			c, err := dialRPCConn(ep.addr, ep.option, ep.connLost)
+			if err != nil {
+				errs[j] = err
+				return
+			}
+			ep.mu.Lock()
+			defer ep.mu.Unlock()
+			if ep.closed {
+				c.Close()
+				return
+			}
+			ep.conns[i] = c
+			ep.goingAway = false
+			ep.setState(Ready)
+		}(j, i)
+	}
+	wg.Wait()
+
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+	ep.dialing = nil
+	ep.dialErr = nil
+	for _, err := range errs {
+		if err != nil {
+			ep.dialErr = err
+			break
+		}
+	}
+	if !ep.closed && ep.usableCount() == 0 {
+		ep.setState(TransientFailure)
+	}
+	close(dialing)
+}
+
+// waitDial 等待正在进行的连接完成，调用时需要持有 ep.mu（等待时释放）
+func (ep *endpoint) waitDial(ctx context.Context) error {
+	dialing := ep.dialing
+	ep.mu.Unlock()
+	defer ep.mu.Lock()
+	select {
+	case <-dialing:
+	case <-ep.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return nil
+}
+
+// getConn 按照 PoolStrategy 从连接池中获取可用的连接，不会等待后台重连
+// 还没有建立过连接、或者（没有开启 Reconnect 时）需要重连时，建立连接并等待（受ctx超时时间限制）
+func (ep *endpoint) getConn(ctx context.Context, redial bool) (*rpcConn, error) {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+
+	if ep.retired {
+		return nil, errConnDraining
+	}
+	if ep.closed {
+		return nil, ErrClient
+	}
+	if ep.state == Idle || (!ep.option.Reconnect && (redial || ep.goingAway) && ep.usableCount() < len(ep.conns)) {
+		ep.startDial()
+	}
+	// 没有可用的连接时，等待不是由后台重连发起的连接
+	waited := false
+	if ep.dialing != nil && !ep.reconnecting && ep.usableCount() == 0 {
+		if err := ep.waitDial(ctx); err != nil {
+			return nil, err
+		}
+		waited = true
+		if ep.retired {
+			return nil, errConnDraining
+		}
+		if ep.closed {
+			return nil, ErrClient
+		}
+	}
+
+	if c := ep.pick(); c != nil {
+		return c, nil
+	}
+	if ep.option.Reconnect {
+		ep.startReconnect()
+	}
+	if waited && ep.dialErr != nil {
+		return nil, ep.dialErr
+	}
+	return nil, ErrServer
+}
+
+// pick 调用时需要持有 ep.mu
+func (ep *endpoint) pick() *rpcConn {
+	var picked *rpcConn
+	switch ep.option.PoolStrategy {
+	case PoolLeastInflight:
+		least := -1
+		for _, c := range ep.conns {
+			if !usable(c) {
+				continue
+			}
+			if n := c.inflight(); least < 0 || n < least {
+				picked, least = c, n
+			}
+		}
+	default:
+		for i := 0; i < len(ep.conns); i++ {
+			c := ep.conns[(int(ep.next)+i)%len(ep.conns)]
+			if usable(c) {
+				picked = c
+				break
+			}
+		}
+		ep.next++
+	}
+	return picked
+}
+
+// waitReady 等待重连成功（仅开启 Reconnect 时有效）
+func (ep *endpoint) waitReady(ctx context.Context) error {
+	ep.mu.Lock()
+	if ep.closed {
+		ep.mu.Unlock()
+		return ErrClient
+	}
+	ep.startReconnect()
+	ready := ep.ready
+	ep.mu.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ep.done:
+		return ErrClient
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// connLost 连接断开或者收到GoAway，所有连接都不可用时状态为 TransientFailure
+func (ep *endpoint) connLost(c *rpcConn) {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+	if ep.closed {
+		return
+	}
+	lost := false
+	for i := range ep.conns {
+		if ep.conns[i] == c {
+			ep.conns[i] = nil
+			lost = true
+		}
+	}
+	if !lost {
+		return
+	}
+	if c.isDraining() {
+		ep.goingAway = true
+	}
+	if ep.usableCount() == 0 {
+		ep.setState(TransientFailure)
+	}
+	if ep.option.Reconnect {
+		ep.startReconnect()
+	}
+}
+
+// startReconnect 连接池没有满时开始重连，调用时需要持有 ep.mu
+func (ep *endpoint) startReconnect() {
+	if ep.reconnecting || ep.closed || ep.usableCount() == len(ep.conns) {
+		return
+	}
+	ep.reconnecting = true
+	go ep.reconnectLoop()
+}
+
+// reconnectLoop 指数退避重连，直到连接池满或者关闭
+func (ep *endpoint) reconnectLoop() {
+	backoff := ep.option.BackoffBase
+	if backoff <= 0 {
+		backoff = DefaultOption.BackoffBase
+	}
+	maxBackoff := ep.option.BackoffMax
+	if maxBackoff < backoff {
+		maxBackoff = backoff
+	}
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(jitter(backoff)):
+			case <-ep.done:
+				return
+			}
+			backoff *= 2
+			if backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+		}
+
+		ep.mu.Lock()
+		if ep.closed {
+			ep.mu.Unlock()
+			return
+		}
+		dialing := ep.startDial()
+		ep.mu.Unlock()
+
+		select {
+		case <-dialing:
+		case <-ep.done:
+			return
+		}
+
+		ep.mu.Lock()
+		if ep.usableCount() == len(ep.conns) {
+			ep.reconnecting = false
+			ep.mu.Unlock()
+			return
+		}
+		ep.mu.Unlock()
+	}
+}
+
+// jitter 在 [0.8d, 1.2d) 之间随机，避免客户端同时重连
+func jitter(d time.Duration) time.Duration {
+	return time.Duration(float64(d) * (0.8 + 0.4*rand.Float64()))
+}
+
+// retire 地址被移除：不再发送新的请求，等待中的请求完成后关闭连接
+func (ep *endpoint) retire() {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+	if ep.closed {
+		return
+	}
+	ep.closed = true
+	ep.retired = true
+	close(ep.done)
+	for _, c := range ep.conns {
+		if c != nil {
+			c.drain()
+		}
+	}
+	ep.setState(Shutdown)
+}
+
+func (ep *endpoint) close() {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+	if ep.closed {
+		return
+	}
+	ep.closed = true
+	close(ep.done)
+	for _, c := range ep.conns {
+		if c != nil {
+			c.Close()
+		}
+	}
+	ep.setState(Shutdown)
+}
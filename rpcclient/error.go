@@ -1,9 +1,48 @@
 package rpcclient
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gofish2020/easyrpc/rpcmsg"
+)
 
 var ErrParam = errors.New("param not adapted")
 
 var ErrClient = errors.New("client disconnection ")
 
 var ErrServer = errors.New("server inner error")
+
+// ErrNoInstance 服务没有可用的地址
+var ErrNoInstance = errors.New("no available service instance")
+
+// ErrCircuitOpen 地址的熔断器打开，请求没有发送
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// errConnDraining 连接收到了GoAway，请求没有发送，需要换一个连接
+var errConnDraining = errors.New("connection is draining")
+
+// RemoteError 服务端方法返回的错误
+// 错误码通过 rpcmsg.RegisterError 注册过哨兵错误时，可以用 errors.Is/As 判断
+type RemoteError struct {
+	Code    rpcmsg.Code
+	Message string
+	Details map[string]string
+}
+
+func newRemoteError(e *rpcmsg.Error) *RemoteError {
+	return &RemoteError{
+		Code:    e.Code,
+		Message: e.Message,
+		Details: e.Details,
+	}
+}
+
+func (e *RemoteError) Error() string {
+	return fmt.Sprintf("remote error: code = %d, message = %s", e.Code, e.Message)
+}
+
+// Unwrap 返回错误码对应的哨兵错误
+func (e *RemoteError) Unwrap() error {
+	return rpcmsg.LookupError(e.Code)
+}
@@ -3,7 +3,22 @@ package rpcclient
 type FailMode int
 
 const (
-	Failover FailMode = iota
-	Failfast
-	Failretry
+	Failover  FailMode = iota // 失败后切换到下一个地址重试
+	Failfast                  // 失败立即返回
+	Failretry                 // 失败后在同一个地址重试
 )
+
+func (mode FailMode) String() string {
+	switch mode {
+	case Failover:
+		return "Failover"
+	case Failfast:
+		return "Failfast"
+	case Failretry:
+		return "Failretry"
+	}
+	return "Unknown"
+}
+
+// RetryHook 请求失败后进行重试时回调（attempt从1开始）
+type RetryHook func(servicePath string, addr string, attempt int, err error)
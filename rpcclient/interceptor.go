@@ -0,0 +1,21 @@
+package rpcclient
+
+import "context"
+
+// Invoker 发送请求并等待响应，results 是按照 stub 返回值类型解码的结果（不包括最后的error）
+type Invoker func(ctx context.Context, servicePath string, args []interface{}) (results []interface{}, err error)
+
+// Interceptor 客户端拦截器：可以在 next 前后增加逻辑（日志、监控、鉴权信息等），也可以不调用 next 直接返回
+type Interceptor func(ctx context.Context, servicePath string, args []interface{}, next Invoker) ([]interface{}, error)
+
+// chainInterceptors 按照顺序组合拦截器，第一个拦截器在最外层
+func chainInterceptors(interceptors []Interceptor, final Invoker) Invoker {
+	invoker := final
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], invoker
+		invoker = func(ctx context.Context, servicePath string, args []interface{}) ([]interface{}, error) {
+			return interceptor(ctx, servicePath, args, next)
+		}
+	}
+	return invoker
+}
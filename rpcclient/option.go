@@ -3,19 +3,48 @@ package rpcclient
 import (
 	"time"
 
+	"github.com/gofish2020/easyrpc/compress"
+	"github.com/gofish2020/easyrpc/registry"
 	"github.com/gofish2020/easyrpc/rpcmsg"
 )
 
 type Option struct {
 	Network        string
-	Retries        int
-	FailMode       FailMode
+	Retries        int       // 失败重试次数（Failfast模式下无效）
+	FailMode       FailMode  // 失败处理策略
+	RetryHook      RetryHook // 重试回调，为nil时打印日志
 	ConnectTimeout time.Duration
-	ReadTimeout    time.Duration
+	ReadTimeout    time.Duration // ctx没有设置超时时间时，默认的请求响应超时时间
 	WriteTimeout   time.Duration
 	SerializeType  rpcmsg.SerializeType
 	CompressType   rpcmsg.CompressType
 	Version        byte
+
+	CompressThreshold int // 编码后的参数小于该字节数时不压缩（数据包头的压缩类型为 None），0表示总是压缩
+	// 替换压缩类型的压缩器，只用于压缩，例如 {rpcmsg.Zstd: compress.GetZstdCompresser(compress.WithLevel(19))}
+	// 压缩级别不影响解压缩；zstd 字典会影响解压缩，需要通过 rpcmsg.RegisterCompressor 注册自定义类型
+	Compressors map[rpcmsg.CompressType]compress.Compression
+
+	MaxFrameSize int // 响应数据包的最大长度，0表示 rpcmsg.DefaultMaxFrameSize
+
+	Reconnect    bool          // 连接断开后在后台自动重连
+	BackoffBase  time.Duration // 第一次重连失败后的等待时间，之后指数增加（带随机抖动）
+	BackoffMax   time.Duration // 重连的最大等待时间
+	WaitForReady bool          // 没有可用的连接时，请求等待重连成功（受ctx超时时间限制），否则立即失败
+	StateHook    StateHook     // 连接状态变化回调，可以为nil
+
+	PoolSize     int          // 每个地址的连接个数，默认1个
+	PoolStrategy PoolStrategy // 从连接池中选择连接的策略
+
+	Registry registry.Registry // 服务发现：按照对象名从注册中心获取服务地址，设置后不需要调用 Connect
+
+	SelectMode     SelectMode            // 负载均衡策略，默认 Sequential
+	SelectModes    map[string]SelectMode // 按照对象名设置负载均衡策略，没有设置的使用 SelectMode
+	CustomSelector Selector              // 自定义负载均衡（所有服务共用），设置后忽略 SelectMode/SelectModes
+
+	Breaker *BreakerOption // 每个地址的熔断配置，nil表示不熔断
+
+	Interceptors []Interceptor // 拦截器，按照顺序执行
 }
 
 var DefaultOption = Option{
@@ -28,4 +57,7 @@ var DefaultOption = Option{
 	SerializeType:  rpcmsg.Gob,
 	CompressType:   rpcmsg.Zlib,
 	Version:        rpcmsg.Version,
+	BackoffBase:    100 * time.Millisecond,
+	BackoffMax:     5 * time.Second,
+	PoolSize:       1,
 }
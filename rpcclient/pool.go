@@ -0,0 +1,19 @@
+package rpcclient
+
+// PoolStrategy 从一个地址的连接池中选择连接的策略
+type PoolStrategy int
+
+const (
+	PoolRoundRobin    PoolStrategy = iota // 依次使用每个连接
+	PoolLeastInflight                     // 使用等待响应的请求最少的连接
+)
+
+func (strategy PoolStrategy) String() string {
+	switch strategy {
+	case PoolRoundRobin:
+		return "RoundRobin"
+	case PoolLeastInflight:
+		return "LeastInflight"
+	}
+	return "Unknown"
+}
@@ -0,0 +1,230 @@
+package rpcclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/gofish2020/easyrpc/registry"
+)
+
+// resolver 一个服务的所有地址：Connect 指定的固定地址，或者从 Registry 发现的地址
+// 持有 r.mu 时不调用 endpoint 的方法（避免一个地址建立连接时阻塞其他地址的请求）
+type resolver struct {
+	option Option
+
+	mu        sync.Mutex
+	endpoints []*endpoint
+	current   int                 // Sequential 当前使用的地址下标
+	selectors map[string]Selector // 每个服务（对象名）的负载均衡策略
+	closed    bool
+}
+
+func newResolver(option Option) *resolver {
+	return &resolver{
+		option:    option,
+		selectors: make(map[string]Selector),
+	}
+}
+
+// connect 按顺序连接第一个可用地址，其余地址作为 Failover 的备选
+func (r *resolver) connect(addrs []string) error {
+	endpoints := make([]*endpoint, len(addrs))
+	for i, addr := range addrs {
+		endpoints[i] = newEndpoint(addr, r.option, nil)
+	}
+	r.mu.Lock()
+	r.endpoints = endpoints
+	r.mu.Unlock()
+
+	var err error
+	for i, ep := range endpoints {
+		if _, err = ep.getConn(context.Background(), true); err != nil {
+			continue
+		}
+		r.mu.Lock()
+		r.current = i
+		r.mu.Unlock()
+		return nil
+	}
+	return err
+}
+
+// watch 从 Registry 获取服务的地址，地址变化时更新
+func (r *resolver) watch(ctx context.Context, reg registry.Registry, service string) error {
+	instances, err := reg.List(service)
+	if err != nil {
+		return err
+	}
+	r.update(instances)
+
+	ch, err := reg.Watch(ctx, service)
+	if err != nil {
+		return err
+	}
+	go func() {
+		for instances := range ch {
+			r.update(instances)
+		}
+	}()
+	return nil
+}
+
+// update 更新服务的地址：保留仍然存在的地址的连接，新的地址在使用时才建立连接，
+// 被移除的地址不再发送新的请求，等待中的请求完成后关闭连接
+func (r *resolver) update(instances []registry.Instance) {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return
+	}
+
+	old := make(map[string]*endpoint, len(r.endpoints))
+	for _, ep := range r.endpoints {
+		old[ep.addr] = ep
+	}
+	var currentAddr string
+	if len(r.endpoints) > 0 {
+		currentAddr = r.endpoints[r.current].addr
+	}
+
+	endpoints := make([]*endpoint, 0, len(instances))
+	kept := make(map[*endpoint]map[string]string)
+	r.current = 0
+	for _, ins := range instances {
+		ep, ok := old[ins.Addr]
+		if ok {
+			delete(old, ins.Addr)
+			kept[ep] = ins.Metadata
+		} else {
+			ep = newEndpoint(ins.Addr, r.option, ins.Metadata)
+		}
+		if ins.Addr == currentAddr {
+			r.current = len(endpoints)
+		}
+		endpoints = append(endpoints, ep)
+	}
+	r.endpoints = endpoints
+	r.mu.Unlock()
+
+	for ep, metadata := range kept {
+		ep.setMetadata(metadata)
+	}
+	for _, ep := range old {
+		ep.retire()
+	}
+}
+
+// selector 服务的负载均衡策略，Sequential 返回nil，调用时需要持有 r.mu
+func (r *resolver) selector(service string) Selector {
+	if r.option.CustomSelector != nil {
+		return r.option.CustomSelector
+	}
+	if sel, ok := r.selectors[service]; ok {
+		return sel
+	}
+	mode, ok := r.option.SelectModes[service]
+	if !ok {
+		mode = r.option.SelectMode
+	}
+	sel := NewSelector(mode)
+	r.selectors[service] = sel
+	return sel
+}
+
+// pick 按照负载均衡策略选择服务的一个地址，exclude 中的地址（本次请求已经失败过）不参与选择，
+// 所有地址都失败过时从全部地址中选择
+func (r *resolver) pick(ctx context.Context, service string, exclude map[string]bool) (*endpoint, error) {
+	r.mu.Lock()
+	if len(r.endpoints) == 0 {
+		r.mu.Unlock()
+		return nil, ErrNoInstance
+	}
+	sel := r.selector(service)
+	if sel == nil {
+		defer r.mu.Unlock()
+		for i := 0; i < len(r.endpoints); i++ {
+			ep := r.endpoints[(r.current+i)%len(r.endpoints)]
+			if !exclude[ep.addr] {
+				return ep, nil
+			}
+		}
+		return r.endpoints[r.current], nil
+	}
+
+	candidates := make([]Endpoint, 0, len(r.endpoints))
+	for _, ep := range r.endpoints {
+		if !exclude[ep.addr] {
+			candidates = append(candidates, ep)
+		}
+	}
+	if len(candidates) == 0 {
+		for _, ep := range r.endpoints {
+			candidates = append(candidates, ep)
+		}
+	}
+	r.mu.Unlock()
+
+	// Selector 会调用 Active/Metadata，不持有 r.mu
+	selected := sel.Select(ctx, candidates)
+	if ep, ok := selected.(*endpoint); ok && ep != nil {
+		return ep, nil
+	}
+	if selected == nil {
+		return nil, fmt.Errorf("%w: selector of %s returned nil", ErrNoInstance, service)
+	}
+	// 自定义 Selector 返回了自己包装的 Endpoint，按照地址查找
+	for _, candidate := range candidates {
+		if candidate.Addr() == selected.Addr() {
+			return candidate.(*endpoint), nil
+		}
+	}
+	return nil, fmt.Errorf("%w: selector of %s returned unknown address %s", ErrNoInstance, service, selected.Addr())
+}
+
+// getConn 获取地址的连接
+// 连接断开时，redial=true 才重新建立连接（开启 Reconnect 时由后台重连）；
+// 连接收到GoAway时，总是重新建立连接；
+// 开启 Reconnect 和 WaitForReady 时，没有可用的连接则等待重连成功（收到GoAway的地址除外）
+func (r *resolver) getConn(ctx context.Context, ep *endpoint, redial bool) (*rpcConn, error) {
+	c, err := ep.getConn(ctx, redial)
+	if err == nil || errors.Is(err, ErrClient) || errors.Is(err, errConnDraining) || ep.isGoingAway() {
+		return c, err
+	}
+
+	if r.option.Reconnect && r.option.WaitForReady {
+		if err := ep.waitReady(ctx); err != nil {
+			return nil, err
+		}
+		return ep.getConn(ctx, false)
+	}
+	return nil, err
+}
+
+func (r *resolver) size() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.endpoints)
+}
+
+// failover Sequential 切换到下一个地址
+func (r *resolver) failover(failedAddr string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.endpoints) == 0 || r.endpoints[r.current].addr != failedAddr {
+		return // 已经被其他请求切换过
+	}
+	r.current = (r.current + 1) % len(r.endpoints)
+}
+
+func (r *resolver) close() {
+	r.mu.Lock()
+	r.closed = true
+	endpoints := r.endpoints
+	r.mu.Unlock()
+
+	for _, ep := range endpoints {
+		ep.close()
+	}
+}
@@ -0,0 +1,228 @@
+package rpcclient
+
+import (
+	"context"
+	"hash/crc32"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Endpoint 服务的一个地址，用于负载均衡
+type Endpoint interface {
+	Addr() string
+	Metadata() map[string]string // 服务发现时的实例信息
+	Active() int                 // 等待响应的请求个数
+}
+
+// Selector 负载均衡：从服务的地址中选择一个（endpoints 不为空），需要并发安全
+// 返回 endpoints 中的一个，或者地址相同的 Endpoint；返回nil时请求失败（ErrNoInstance）
+type Selector interface {
+	Select(ctx context.Context, endpoints []Endpoint) Endpoint
+}
+
+// SelectMode 负载均衡策略
+type SelectMode int
+
+const (
+	Sequential         SelectMode = iota // 按顺序使用第一个可用的地址，Failover 时切换到下一个
+	Random                               // 随机
+	RoundRobin                           // 轮询
+	WeightedRoundRobin                   // 按照实例的 weight 加权轮询
+	LeastActive                          // 等待响应的请求最少
+	ConsistentHash                       // 按照 WithHashKey 设置的key一致性哈希，没有设置key时随机
+)
+
+func (mode SelectMode) String() string {
+	switch mode {
+	case Sequential:
+		return "Sequential"
+	case Random:
+		return "Random"
+	case RoundRobin:
+		return "RoundRobin"
+	case WeightedRoundRobin:
+		return "WeightedRoundRobin"
+	case LeastActive:
+		return "LeastActive"
+	case ConsistentHash:
+		return "ConsistentHash"
+	}
+	return "Unknown"
+}
+
+// WeightKey 实例信息中的权重，默认为1
+const WeightKey = "weight"
+
+// NewSelector 创建负载均衡策略，Sequential 没有对应的 Selector，返回nil
+func NewSelector(mode SelectMode) Selector {
+	switch mode {
+	case Random:
+		return randomSelector{}
+	case RoundRobin:
+		return &roundRobinSelector{}
+	case WeightedRoundRobin:
+		return &weightedRoundRobinSelector{current: make(map[string]int)}
+	case LeastActive:
+		return leastActiveSelector{}
+	case ConsistentHash:
+		return &consistentHashSelector{replicas: 100}
+	}
+	return nil
+}
+
+type hashKey struct{}
+
+// WithHashKey 设置一致性哈希的key，相同key的请求发送到同一个地址（例如按照用户id）
+func WithHashKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, hashKey{}, key)
+}
+
+// HashKeyFromContext 获取 WithHashKey 设置的key
+func HashKeyFromContext(ctx context.Context) (string, bool) {
+	key, ok := ctx.Value(hashKey{}).(string)
+	return key, ok
+}
+
+type randomSelector struct{}
+
+func (randomSelector) Select(ctx context.Context, endpoints []Endpoint) Endpoint {
+	return endpoints[rand.Intn(len(endpoints))]
+}
+
+type roundRobinSelector struct {
+	next uint32
+}
+
+func (s *roundRobinSelector) Select(ctx context.Context, endpoints []Endpoint) Endpoint {
+	n := atomic.AddUint32(&s.next, 1) - 1
+	return endpoints[int(n%uint32(len(endpoints)))]
+}
+
+// weightedRoundRobinSelector 平滑加权轮询：每次所有地址的当前权重加上自己的权重，
+// 选择当前权重最大的地址，并减去权重总和
+type weightedRoundRobinSelector struct {
+	mu      sync.Mutex
+	current map[string]int
+}
+
+func weight(ep Endpoint) int {
+	w, err := strconv.Atoi(ep.Metadata()[WeightKey])
+	if err != nil || w <= 0 {
+		return 1
+	}
+	return w
+}
+
+func (s *weightedRoundRobinSelector) Select(ctx context.Context, endpoints []Endpoint) Endpoint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	total := 0
+	var best Endpoint
+	for _, ep := range endpoints {
+		w := weight(ep)
+		total += w
+		s.current[ep.Addr()] += w
+		if best == nil || s.current[ep.Addr()] > s.current[best.Addr()] {
+			best = ep
+		}
+	}
+	s.current[best.Addr()] -= total
+
+	// 清理已经不存在的地址
+	if len(s.current) > len(endpoints) {
+		alive := make(map[string]bool, len(endpoints))
+		for _, ep := range endpoints {
+			alive[ep.Addr()] = true
+		}
+		for addr := range s.current {
+			if !alive[addr] {
+				delete(s.current, addr)
+			}
+		}
+	}
+	return best
+}
+
+type leastActiveSelector struct{}
+
+// Select 请求数相同的地址中随机选择
+func (leastActiveSelector) Select(ctx context.Context, endpoints []Endpoint) Endpoint {
+	least := -1
+	candidates := make([]Endpoint, 0, len(endpoints))
+	for _, ep := range endpoints {
+		active := ep.Active()
+		if least < 0 || active < least {
+			least = active
+			candidates = candidates[:0]
+		}
+		if active == least {
+			candidates = append(candidates, ep)
+		}
+	}
+	return candidates[rand.Intn(len(candidates))]
+}
+
+// consistentHashSelector 一致性哈希：每个地址在哈希环上有 replicas 个虚拟节点，
+// 地址列表变化时重新生成哈希环
+type consistentHashSelector struct {
+	replicas int
+
+	mu    sync.Mutex
+	addrs string // 生成哈希环的地址列表
+	ring  []uint32
+	nodes map[uint32]string
+}
+
+func (s *consistentHashSelector) Select(ctx context.Context, endpoints []Endpoint) Endpoint {
+	key, ok := HashKeyFromContext(ctx)
+	if !ok {
+		return randomSelector{}.Select(ctx, endpoints)
+	}
+
+	s.mu.Lock()
+	s.build(endpoints)
+	h := crc32.ChecksumIEEE([]byte(key))
+	i := sort.Search(len(s.ring), func(i int) bool { return s.ring[i] >= h })
+	if i == len(s.ring) {
+		i = 0
+	}
+	addr := s.nodes[s.ring[i]]
+	s.mu.Unlock()
+
+	for _, ep := range endpoints {
+		if ep.Addr() == addr {
+			return ep
+		}
+	}
+	return endpoints[0]
+}
+
+// build 调用时需要持有 s.mu
+func (s *consistentHashSelector) build(endpoints []Endpoint) {
+	addrs := make([]string, len(endpoints))
+	for i, ep := range endpoints {
+		addrs[i] = ep.Addr()
+	}
+	sort.Strings(addrs)
+	joined := strings.Join(addrs, ",")
+	if joined == s.addrs && s.ring != nil {
+		return
+	}
+
+	s.addrs = joined
+	s.ring = make([]uint32, 0, len(addrs)*s.replicas)
+	s.nodes = make(map[uint32]string, len(addrs)*s.replicas)
+	for _, addr := range addrs {
+		for i := 0; i < s.replicas; i++ {
+			h := crc32.ChecksumIEEE([]byte(strconv.Itoa(i) + "#" + addr))
+			s.ring = append(s.ring, h)
+			s.nodes[h] = addr
+		}
+	}
+	sort.Slice(s.ring, func(i, j int) bool { return s.ring[i] < s.ring[j] })
+}
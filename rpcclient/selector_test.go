@@ -0,0 +1,175 @@
+package rpcclient
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/gofish2020/easyrpc/registry"
+	"github.com/gofish2020/easyrpc/rpcmsg"
+	"github.com/stretchr/testify/assert"
+)
+
+type testEndpoint struct {
+	addr     string
+	metadata map[string]string
+	active   int
+}
+
+func (ep *testEndpoint) Addr() string                { return ep.addr }
+func (ep *testEndpoint) Metadata() map[string]string { return ep.metadata }
+func (ep *testEndpoint) Active() int                 { return ep.active }
+
+func newTestEndpoints(weights ...int) []Endpoint {
+	endpoints := make([]Endpoint, len(weights))
+	for i, w := range weights {
+		endpoints[i] = &testEndpoint{
+			addr:     fmt.Sprintf("127.0.0.1:%d", 6060+i),
+			metadata: map[string]string{WeightKey: fmt.Sprint(w)},
+		}
+	}
+	return endpoints
+}
+
+func selectAddrs(sel Selector, ctx context.Context, endpoints []Endpoint, n int) []string {
+	addrs := make([]string, n)
+	for i := range addrs {
+		addrs[i] = sel.Select(ctx, endpoints).Addr()
+	}
+	return addrs
+}
+
+func TestRoundRobinSelector(t *testing.T) {
+	endpoints := newTestEndpoints(1, 1, 1)
+	addrs := selectAddrs(NewSelector(RoundRobin), context.Background(), endpoints, 6)
+	for i, addr := range addrs {
+		assert.Equal(t, endpoints[i%3].Addr(), addr)
+	}
+}
+
+func TestWeightedRoundRobinSelector(t *testing.T) {
+	endpoints := newTestEndpoints(5, 1, 1)
+	a, b, c := endpoints[0].Addr(), endpoints[1].Addr(), endpoints[2].Addr()
+	// 平滑加权：权重大的地址不会连续被选中
+	addrs := selectAddrs(NewSelector(WeightedRoundRobin), context.Background(), endpoints, 7)
+	assert.Equal(t, []string{a, a, b, a, c, a, a}, addrs)
+}
+
+func TestLeastActiveSelector(t *testing.T) {
+	endpoints := newTestEndpoints(1, 1, 1)
+	endpoints[0].(*testEndpoint).active = 3
+	endpoints[1].(*testEndpoint).active = 1
+	endpoints[2].(*testEndpoint).active = 2
+	for _, addr := range selectAddrs(NewSelector(LeastActive), context.Background(), endpoints, 5) {
+		assert.Equal(t, endpoints[1].Addr(), addr)
+	}
+}
+
+func TestConsistentHashSelector(t *testing.T) {
+	sel := NewSelector(ConsistentHash)
+	endpoints := newTestEndpoints(1, 1, 1, 1)
+
+	keys := make(map[string]string)
+	for i := 0; i < 100; i++ {
+		ctx := WithHashKey(context.Background(), fmt.Sprint(i))
+		addrs := selectAddrs(sel, ctx, endpoints, 3)
+		assert.Equal(t, addrs[0], addrs[1])
+		assert.Equal(t, addrs[0], addrs[2])
+		keys[fmt.Sprint(i)] = addrs[0]
+	}
+
+	// 移除一个地址，其余地址上的key不受影响
+	removed := endpoints[3].Addr()
+	for key, addr := range keys {
+		ctx := WithHashKey(context.Background(), key)
+		if addr != removed {
+			assert.Equal(t, addr, sel.Select(ctx, endpoints[:3]).Addr())
+		}
+	}
+}
+
+func TestSelectModePerService(t *testing.T) {
+	// 每个服务返回自己的地址
+	reg := registry.NewMemoryRegistry()
+	for i := 0; i < 3; i++ {
+		var server net.Listener
+		server = fakeServer(t, func(conn net.Conn) {
+			defer conn.Close()
+			for {
+				msg, err := rpcmsg.RecvFrom(conn)
+				if err != nil {
+					return
+				}
+				reply(conn, msg, server.Addr().String())
+			}
+		})
+		defer server.Close()
+		for _, service := range []string{"User", "Order"} {
+			assert.Equal(t, nil, reg.Register(registry.Instance{Service: service, Addr: server.Addr().String()}))
+		}
+	}
+
+	option := DefaultOption
+	option.Registry = reg
+	option.SelectMode = RoundRobin
+	option.SelectModes = map[string]SelectMode{"User": ConsistentHash}
+	client := NewRPCClient(option)
+	defer client.Close()
+
+	var getUser, getOrder func(ctx context.Context) (string, error)
+	_, err := client.Call(context.Background(), "User.Get", &getUser)
+	assert.Equal(t, nil, err)
+	_, err = client.Call(context.Background(), "Order.Get", &getOrder)
+	assert.Equal(t, nil, err)
+
+	// 相同用户的请求发送到同一个地址
+	for _, uid := range []string{"1", "2", "3"} {
+		ctx := WithHashKey(context.Background(), uid)
+		first, err := getUser(ctx)
+		assert.Equal(t, nil, err)
+		for i := 0; i < 3; i++ {
+			addr, err := getUser(ctx)
+			assert.Equal(t, nil, err)
+			assert.Equal(t, first, addr)
+		}
+	}
+
+	// 轮询使用所有地址
+	addrs := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		addr, err := getOrder(context.Background())
+		assert.Equal(t, nil, err)
+		addrs[addr] = true
+	}
+	assert.Equal(t, 3, len(addrs))
+}
+
+// funcSelector 自定义负载均衡
+type funcSelector func(endpoints []Endpoint) Endpoint
+
+func (f funcSelector) Select(ctx context.Context, endpoints []Endpoint) Endpoint {
+	return f(endpoints)
+}
+
+func TestCustomSelectorResult(t *testing.T) {
+	r := newResolver(Option{})
+	r.endpoints = []*endpoint{newEndpoint("a", Option{}, nil), newEndpoint("b", Option{}, nil)}
+	defer r.close()
+
+	// 返回包装的 Endpoint，按照地址找到对应的地址
+	r.option.CustomSelector = funcSelector(func(endpoints []Endpoint) Endpoint {
+		return &testEndpoint{addr: endpoints[1].Addr()}
+	})
+	ep, err := r.pick(context.Background(), "User", nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "b", ep.Addr())
+
+	// 返回nil或者不存在的地址
+	r.option.CustomSelector = funcSelector(func(endpoints []Endpoint) Endpoint { return nil })
+	_, err = r.pick(context.Background(), "User", nil)
+	assert.ErrorIs(t, err, ErrNoInstance)
+	r.option.CustomSelector = funcSelector(func(endpoints []Endpoint) Endpoint { return &testEndpoint{addr: "c"} })
+	_, err = r.pick(context.Background(), "User", nil)
+	assert.ErrorIs(t, err, ErrNoInstance)
+}
@@ -1,6 +1,8 @@
 package rpcclient
 
 import (
+	"context"
+
 	"github.com/gofish2020/easyrpc/rpcmsg"
 	"github.com/gofish2020/easyrpc/utils"
 )
@@ -23,9 +25,14 @@ func (t *waitMsg) GetSeq() int64 {
 	return t.seq
 }
 
-func (t *waitMsg) Wait() *rpcmsg.RPCMsg {
-	<-t.done
-	return t.msg
+// Wait 等待响应，ctx结束时返回 ctx.Err()
+func (t *waitMsg) Wait(ctx context.Context) (*rpcmsg.RPCMsg, error) {
+	select {
+	case <-t.done:
+		return t.msg, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
 }
 
 func (t *waitMsg) Ready(msg *rpcmsg.RPCMsg) {
@@ -0,0 +1,80 @@
+package rpcmsg
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/gofish2020/easyrpc/codec"
+)
+
+// ErrArgsMismatch 参数个数或类型和声明的不一致
+var ErrArgsMismatch = errors.New("arguments mismatch")
+
+// EncodeArgs 参数逐个编码，接收方可以按照参数类型解码
+// ********格式： 【参数个数 4字节】【参数长度 4字节】【参数】...*******
+// nil值（nil指针、nil切片等）的长度为0，解码为类型的零值
+func EncodeArgs(codeTool codec.Codec, args []interface{}) ([]byte, error) {
+	data := binary.BigEndian.AppendUint32(nil, uint32(len(args)))
+	for i, arg := range args {
+		if isNil(arg) {
+			data = binary.BigEndian.AppendUint32(data, 0)
+			continue
+		}
+		encodeRes, err := codeTool.Encode(arg)
+		if err != nil {
+			return nil, fmt.Errorf("encode argument %d error: %w", i, err)
+		}
+		data = binary.BigEndian.AppendUint32(data, uint32(len(encodeRes)))
+		data = append(data, encodeRes...)
+	}
+	return data, nil
+}
+
+// DecodeArgs 按照 types 逐个解码参数
+func DecodeArgs(codeTool codec.Codec, data []byte, types []reflect.Type) ([]reflect.Value, error) {
+	if uint32(len(data)) < DATA_LEN {
+		return nil, fmt.Errorf("arguments format error")
+	}
+	count := binary.BigEndian.Uint32(data)
+	data = data[DATA_LEN:]
+	if int(count) != len(types) {
+		return nil, fmt.Errorf("%w: needs %d arguments, got %d", ErrArgsMismatch, len(types), count)
+	}
+
+	values := make([]reflect.Value, len(types))
+	for i, typ := range types {
+		if uint32(len(data)) < DATA_LEN {
+			return nil, fmt.Errorf("argument %d format error", i)
+		}
+		argLen := binary.BigEndian.Uint32(data)
+		data = data[DATA_LEN:]
+		if uint32(len(data)) < argLen {
+			return nil, fmt.Errorf("argument %d format error", i)
+		}
+		if argLen == 0 {
+			values[i] = reflect.Zero(typ)
+			continue
+		}
+		value := reflect.New(typ)
+		if err := codeTool.Decode(data[:argLen], value.Interface()); err != nil {
+			return nil, fmt.Errorf("%w: decode argument %d to %s error: %v", ErrArgsMismatch, i, typ, err)
+		}
+		values[i] = value.Elem()
+		data = data[argLen:]
+	}
+	return values, nil
+}
+
+func isNil(arg interface{}) bool {
+	if arg == nil {
+		return true
+	}
+	v := reflect.ValueOf(arg)
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface, reflect.Chan, reflect.Func:
+		return v.IsNil()
+	}
+	return false
+}
@@ -0,0 +1,80 @@
+package rpcmsg
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/gofish2020/easyrpc/codec"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type userInfo struct {
+	Name string `json:"name"`
+	Id   uint64 `json:"id"`
+}
+
+func TestArgs(t *testing.T) {
+	var nilInfo *userInfo
+	args := []interface{}{1, "nash", userInfo{Name: "nash", Id: 1}, &userInfo{Name: "yu", Id: 2}, []int{1, 2, 3}, nilInfo}
+	types := make([]reflect.Type, len(args))
+	for i := range args {
+		types[i] = reflect.TypeOf(args[i])
+	}
+
+	// 不需要 gob.Register，json 也可以解码为具体的类型
+	for _, codeTool := range []codec.Codec{codec.GobCodec{}, codec.JsonCodec{}, codec.MsgPackCodec{}} {
+		data, err := EncodeArgs(codeTool, args)
+		assert.Equal(t, nil, err)
+
+		values, err := DecodeArgs(codeTool, data, types)
+		assert.Equal(t, nil, err)
+		for i := range values {
+			assert.Equal(t, args[i], values[i].Interface())
+		}
+
+		_, err = DecodeArgs(codeTool, data, types[:2])
+		assert.True(t, errors.Is(err, ErrArgsMismatch))
+
+		types[0], types[1] = types[1], types[0]
+		_, err = DecodeArgs(codeTool, data, types)
+		assert.True(t, errors.Is(err, ErrArgsMismatch))
+		types[0], types[1] = types[1], types[0]
+	}
+}
+
+func TestProtobufArgs(t *testing.T) {
+	var nilValue *wrapperspb.StringValue
+	args := []interface{}{wrapperspb.String("nash"), wrapperspb.Int64(1), nilValue}
+	types := []reflect.Type{reflect.TypeOf(args[0]), reflect.TypeOf(args[1]), reflect.TypeOf(args[2])}
+
+	codeTool := LookupCodec(Protobuf)
+	data, err := EncodeArgs(codeTool, args)
+	assert.Equal(t, nil, err)
+	values, err := DecodeArgs(codeTool, data, types)
+	assert.Equal(t, nil, err)
+	for i := range values {
+		if args[i] == nilValue {
+			assert.True(t, values[i].IsNil())
+			continue
+		}
+		assert.True(t, proto.Equal(args[i].(proto.Message), values[i].Interface().(proto.Message)))
+	}
+
+	// 不是 proto.Message 的参数
+	_, err = EncodeArgs(codeTool, []interface{}{1})
+	assert.NotNil(t, err)
+}
+
+func TestCodecs(t *testing.T) {
+	// Json 使用的是 json 编码
+	data, err := LookupCodec(Json).Encode(userInfo{Name: "nash", Id: 1})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, `{"name":"nash","id":1}`, string(data))
+
+	for _, typ := range []SerializeType{Gob, Json, Protobuf, MsgPack} {
+		assert.NotNil(t, LookupCodec(typ), typ)
+	}
+}
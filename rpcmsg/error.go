@@ -0,0 +1,189 @@
+package rpcmsg
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// 错误码
+type Code uint32
+
+const (
+	CodeOK              Code = iota
+	CodeUnknown              // 未注册错误码的业务错误
+	CodeServiceNotFound      // ObjectName 没有注册
+	CodeMethodNotFound       // MethodName 不存在
+	CodeBadRequest           // 请求数据包无法解压缩/解码
+	CodeInternal             // 服务端内部错误（方法panic、结果编码失败等）
+	CodeInvalidArgument      // 入参个数或类型和方法不一致
+	CodeUnavailable          // 服务正在关闭，请求没有执行（可以重试其他服务）
+)
+
+// CodeUserBase 小于该值的错误码由框架保留，业务错误码从这里开始
+const CodeUserBase Code = 1000
+
+// Error 在响应数据包中传输的错误
+type Error struct {
+	Code    Code
+	Message string
+	Details map[string]string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("code = %d, message = %s", e.Code, e.Message)
+}
+
+// Unwrap 返回错误码对应的哨兵错误
+func (e *Error) Unwrap() error {
+	return LookupError(e.Code)
+}
+
+// 框架错误码对应的哨兵错误
+var (
+	ErrServiceNotFound = errors.New("service not found")
+	ErrMethodNotFound  = errors.New("method not found")
+	ErrBadRequest      = errors.New("bad request")
+	ErrInternal        = errors.New("server internal error")
+	ErrInvalidArgument = errors.New("invalid argument")
+	ErrUnavailable     = errors.New("server unavailable")
+)
+
+var (
+	errMu     sync.RWMutex
+	errByCode = map[Code]error{
+		CodeServiceNotFound: ErrServiceNotFound,
+		CodeMethodNotFound:  ErrMethodNotFound,
+		CodeBadRequest:      ErrBadRequest,
+		CodeInternal:        ErrInternal,
+		CodeInvalidArgument: ErrInvalidArgument,
+		CodeUnavailable:     ErrUnavailable,
+	}
+)
+
+// NewError 创建传输的错误
+func NewError(code Code, format string, a ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, a...)}
+}
+
+// RegisterError 注册错误码对应的哨兵错误，服务端和客户端需要注册相同的错误码
+// 服务端方法返回该错误时，按错误码传输；客户端收到后可以用 errors.Is 判断
+func RegisterError(code Code, sentinel error) {
+	if code < CodeUserBase {
+		panic(fmt.Sprintf("rpcmsg: error code %d is reserved", code))
+	}
+	errMu.Lock()
+	defer errMu.Unlock()
+	if _, ok := errByCode[code]; ok {
+		panic(fmt.Sprintf("rpcmsg: error code %d is registered", code))
+	}
+	errByCode[code] = sentinel
+}
+
+// LookupError 错误码对应的哨兵错误，未注册返回nil
+func LookupError(code Code) error {
+	errMu.RLock()
+	defer errMu.RUnlock()
+	return errByCode[code]
+}
+
+// ToError 将方法返回的错误转换为传输的错误
+func ToError(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+
+	// errors.Is 会调用 Unwrap（需要加锁），这里先复制一份
+	errMu.RLock()
+	sentinels := make(map[Code]error, len(errByCode))
+	for code, sentinel := range errByCode {
+		sentinels[code] = sentinel
+	}
+	errMu.RUnlock()
+
+	for code, sentinel := range sentinels {
+		if errors.Is(err, sentinel) {
+			return &Error{Code: code, Message: err.Error()}
+		}
+	}
+	return &Error{Code: CodeUnknown, Message: err.Error()}
+}
+
+// encode 【错误码 4字节】【Message长度 4字节】【Message】【Details个数 4字节】【key长度 key value长度 value】...
+func (e *Error) encode() []byte {
+	size := 4 + DATA_LEN + uint32(len(e.Message)) + 4
+	for k, v := range e.Details {
+		size += DATA_LEN + uint32(len(k)) + DATA_LEN + uint32(len(v))
+	}
+	data := make([]byte, 0, size)
+	data = binary.BigEndian.AppendUint32(data, uint32(e.Code))
+	data = appendString(data, e.Message)
+	data = binary.BigEndian.AppendUint32(data, uint32(len(e.Details)))
+	for k, v := range e.Details {
+		data = appendString(data, k)
+		data = appendString(data, v)
+	}
+	return data
+}
+
+func decodeError(data []byte) (*Error, error) {
+	e := &Error{}
+	if len(data) < 4 {
+		return nil, errBadError
+	}
+	e.Code = Code(binary.BigEndian.Uint32(data))
+	data = data[4:]
+
+	var ok bool
+	if e.Message, data, ok = readString(data); !ok {
+		return nil, errBadError
+	}
+	if len(data) < 4 {
+		return nil, errBadError
+	}
+	count := binary.BigEndian.Uint32(data)
+	data = data[4:]
+	if !validPairCount(count, data) {
+		return nil, errBadError
+	}
+	if count > 0 {
+		e.Details = make(map[string]string, count)
+	}
+	for i := uint32(0); i < count; i++ {
+		var k, v string
+		if k, data, ok = readString(data); !ok {
+			return nil, errBadError
+		}
+		if v, data, ok = readString(data); !ok {
+			return nil, errBadError
+		}
+		e.Details[k] = v
+	}
+	return e, nil
+}
+
+var errBadError = errors.New("error section format error")
+
+func appendString(data []byte, s string) []byte {
+	data = binary.BigEndian.AppendUint32(data, uint32(len(s)))
+	return append(data, s...)
+}
+
+// validPairCount 每个 key/value 至少占用两个长度字段，个数来自网络数据，分配 map 之前需要检查
+func validPairCount(count uint32, data []byte) bool {
+	return uint64(count) <= uint64(len(data))/uint64(2*DATA_LEN)
+}
+
+func readString(data []byte) (string, []byte, bool) {
+	if uint32(len(data)) < DATA_LEN {
+		return "", nil, false
+	}
+	n := binary.BigEndian.Uint32(data)
+	data = data[DATA_LEN:]
+	if uint32(len(data)) < n {
+		return "", nil, false
+	}
+	return string(data[:n]), data[n:], true
+}
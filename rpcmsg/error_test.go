@@ -0,0 +1,64 @@
+package rpcmsg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var errTestNotFound = errors.New("not found")
+
+func init() {
+	RegisterError(CodeUserBase+1, errTestNotFound)
+}
+
+func TestToError(t *testing.T) {
+	e := ToError(fmt.Errorf("user 1: %w", errTestNotFound))
+	assert.Equal(t, CodeUserBase+1, e.Code)
+	assert.Equal(t, "user 1: not found", e.Message)
+	assert.Equal(t, errTestNotFound, LookupError(e.Code))
+
+	e = ToError(errors.New("unknown"))
+	assert.Equal(t, CodeUnknown, e.Code)
+
+	custom := &Error{Code: CodeUserBase + 2, Message: "custom", Details: map[string]string{"id": "1"}}
+	assert.Equal(t, custom, ToError(fmt.Errorf("wrap: %w", custom)))
+
+	assert.Panics(t, func() { RegisterError(CodeUnknown, errTestNotFound) })
+	assert.Panics(t, func() { RegisterError(CodeUserBase+1, errTestNotFound) })
+}
+
+func TestMsgError(t *testing.T) {
+	msg := NewRPCMsg()
+	msg.SetMsgType(Response)
+	msg.Seq = 10
+	msg.Error = &Error{Code: CodeUserBase + 1, Message: "not found", Details: map[string]string{"id": "1", "name": "nash"}}
+
+	var buf bytes.Buffer
+	err := msg.SendMsg(&buf)
+	assert.Equal(t, nil, err)
+
+	msg2, err := RecvFrom(&buf)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, msg.Seq, msg2.Seq)
+	assert.Equal(t, msg.Error, msg2.Error)
+	assert.Equal(t, 0, len(msg2.Payload))
+
+	// 没有错误时不发送 Error 字段
+	msg.Error = nil
+	buf.Reset()
+	msg.SendMsg(&buf)
+	msg3, err := RecvFrom(&buf)
+	assert.Equal(t, nil, err)
+	assert.Nil(t, msg3.Error)
+
+	// Details 的个数超过数据长度，不分配内存直接返回错误
+	data := (&Error{Code: CodeInternal, Message: "boom"}).encode()
+	binary.BigEndian.PutUint32(data[len(data)-4:], 50000000)
+	_, err = decodeError(data)
+	assert.Equal(t, errBadError, err)
+}
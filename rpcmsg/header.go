@@ -5,7 +5,7 @@ package rpcmsg
 
 const (
 	magicNumber byte = 0xFF // 魔法数
-	Version     byte = 0x01 //协议版本
+	Version     byte = 0x02 //协议版本（0x02 增加了 Metadata）
 	HEADER_LEN  int  = 5    // 固定5字节
 )
 
@@ -15,6 +15,7 @@ type MsgType byte
 const (
 	Request MsgType = iota
 	Response
+	GoAway // 服务端即将关闭，客户端不要在该连接上发送新的请求
 )
 
 // 压缩类型
@@ -25,6 +26,8 @@ const (
 	Zlib
 	Snappy
 	Lz4
+	Zstd
+	Gzip
 )
 
 // 序列化类型
@@ -33,6 +36,8 @@ type SerializeType byte
 const (
 	Gob SerializeType = iota
 	Json
+	Protobuf // 参数和结果必须是 proto.Message
+	MsgPack
 )
 
 func NewHeader() Header {
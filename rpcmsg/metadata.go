@@ -0,0 +1,47 @@
+package rpcmsg
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/gofish2020/easyrpc/metadata"
+)
+
+// VersionMetadata 支持 Metadata 字段的最低协议版本，对方版本较低时不发送 Metadata
+const VersionMetadata byte = 0x02
+
+var errBadMetadata = errors.New("metadata section format error")
+
+// 格式：【个数 uint32】【key长度 key value长度 value】...
+func encodeMetadata(md metadata.MD) []byte {
+	data := binary.BigEndian.AppendUint32(nil, uint32(len(md)))
+	for k, v := range md {
+		data = appendString(data, k)
+		data = appendString(data, v)
+	}
+	return data
+}
+
+func decodeMetadata(data []byte) (metadata.MD, error) {
+	if len(data) < 4 {
+		return nil, errBadMetadata
+	}
+	count := binary.BigEndian.Uint32(data)
+	data = data[4:]
+	if !validPairCount(count, data) {
+		return nil, errBadMetadata
+	}
+	md := make(metadata.MD, count)
+	for i := uint32(0); i < count; i++ {
+		var k, v string
+		var ok bool
+		if k, data, ok = readString(data); !ok {
+			return nil, errBadMetadata
+		}
+		if v, data, ok = readString(data); !ok {
+			return nil, errBadMetadata
+		}
+		md[k] = v
+	}
+	return md, nil
+}
@@ -1,16 +1,23 @@
 package rpcmsg
 
 import (
+	"bufio"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
+	"sync"
+	"time"
 
+	"github.com/gofish2020/easyrpc/metadata"
 	"github.com/gofish2020/easyrpc/utils"
 )
 
 const (
-	DATA_LEN uint32 = 4
+	DATA_LEN    uint32 = 4
+	TIMEOUT_LEN uint32 = 8
 )
 
 // RPCMsg: 一个完整的数据包 header + body
@@ -23,6 +30,13 @@ type RPCMsg struct {
 	MethodName string
 	// uint32 表示长度
 	Payload []byte
+	// 8字节 请求剩余的超时时间，0表示不限制（扩展字段：位于Payload之后，旧版本解析时会忽略）
+	Timeout time.Duration
+	// uint32 表示长度 方法执行返回的错误，仅响应数据包（扩展字段：位于Timeout之后，没有错误时不发送）
+	Error *Error
+	// uint32 表示长度 请求的 metadata 或者响应的 trailer（扩展字段：位于Error之后，为空时不发送；
+	// 存在时Error字段长度为0表示没有错误。协议版本低于 VersionMetadata 时不发送）
+	Metadata metadata.MD
 }
 
 func NewRPCMsg() *RPCMsg {
@@ -33,117 +47,219 @@ func NewRPCMsg() *RPCMsg {
 	return &rpcMsg
 }
 
-// SendMsg 发送消息
+// 编码后的数据包超过该容量时不放回池中
+const maxPooledFrameSize = 1 << 20
+
+// Payload 超过该长度时不复制到数据包缓冲区，使用 net.Buffers 和其他部分一起写入（writev）
+const largePayloadSize = 32 << 10
+
+var framePool = sync.Pool{
+	New: func() interface{} {
+		frame := make([]byte, 0, 512)
+		return &frame
+	},
+}
+
+// SendMsg 发送消息：整个数据包编码到池中的缓冲区，一次写入（较大的 Payload 不复制，通过 net.Buffers 写入）
 func (t *RPCMsg) SendMsg(w io.Writer) error {
-	var err error
-	//******
-	_, err = w.Write(t.Header[:]) // 1.发送header头 5字节
-	if err != nil {
-		return err
+	var errData, mdData []byte
+	if t.Error != nil {
+		errData = t.Error.encode()
 	}
-
-	err = binary.Write(w, binary.BigEndian, uint64(t.Seq)) // 8字节
-	if err != nil {
-		return err
+	if len(t.Metadata) > 0 && t.Version() >= VersionMetadata {
+		mdData = encodeMetadata(t.Metadata)
 	}
-	//******
-	totalLen := DATA_LEN + uint32(len(t.ObjectName)) + DATA_LEN + uint32(len(t.MethodName)) + DATA_LEN + uint32(len(t.Payload))
-	err = binary.Write(w, binary.BigEndian, uint32(totalLen)) // 2.写入总长度 4字节
-	if err != nil {
-		return err
+	totalLen := DATA_LEN + uint32(len(t.ObjectName)) + DATA_LEN + uint32(len(t.MethodName)) + DATA_LEN + uint32(len(t.Payload)) + TIMEOUT_LEN
+	if t.Error != nil || mdData != nil {
+		totalLen += DATA_LEN + uint32(len(errData))
 	}
-	//******
-	err = binary.Write(w, binary.BigEndian, uint32(len(t.ObjectName))) // 3.写入 ObjectName 长度
-	if err != nil {
-		return err
+	if mdData != nil {
+		totalLen += DATA_LEN + uint32(len(mdData))
 	}
-	_, err = w.Write(utils.String2Bytes(t.ObjectName)) // 4.写入 ObjectName
-	if err != nil {
-		return err
+
+	framePtr := framePool.Get().(*[]byte)
+	defer func() {
+		if cap(*framePtr) <= maxPooledFrameSize {
+			framePool.Put(framePtr)
+		}
+	}()
+
+	frame := (*framePtr)[:0]
+	frame = append(frame, t.Header[:]...)                                // 1.header头 5字节
+	frame = binary.BigEndian.AppendUint64(frame, uint64(t.Seq))          // 8字节
+	frame = binary.BigEndian.AppendUint32(frame, totalLen)               // 2.总长度 4字节
+	frame = appendBytes(frame, utils.String2Bytes(t.ObjectName))         // 3.ObjectName
+	frame = appendBytes(frame, utils.String2Bytes(t.MethodName))         // 4.MethodName
+	frame = binary.BigEndian.AppendUint32(frame, uint32(len(t.Payload))) // 5.Payload 长度
+	large := len(t.Payload) > largePayloadSize
+	if !large {
+		frame = append(frame, t.Payload...) // 6.Payload
+	}
+	headLen := len(frame)
+	frame = binary.BigEndian.AppendUint64(frame, uint64(t.Timeout)) // 7.Timeout 8字节
+	if t.Error != nil || mdData != nil {
+		frame = appendBytes(frame, errData) // 8.Error
 	}
+	if mdData != nil {
+		frame = appendBytes(frame, mdData) // 9.Metadata
+	}
+	*framePtr = frame
 
-	//******
-	err = binary.Write(w, binary.BigEndian, uint32(len(t.MethodName))) // 5.写入 MethodName 长度
-	if err != nil {
+	if !large {
+		_, err := w.Write(frame)
 		return err
 	}
-	_, err = w.Write(utils.String2Bytes(t.MethodName)) // 6.写入 MethodName
-	if err != nil {
-		return err
+	buffers := net.Buffers{frame[:headLen], t.Payload, frame[headLen:]}
+	_, err := buffers.WriteTo(w)
+	return err
+}
+
+// appendBytes 追加 uint32 长度 + 数据
+func appendBytes(frame, data []byte) []byte {
+	frame = binary.BigEndian.AppendUint32(frame, uint32(len(data)))
+	return append(frame, data...)
+}
+
+// 固定长度部分：header + seq + 总长度
+const prefixLen = HEADER_LEN + 8 + 4
+
+// DefaultMaxFrameSize 默认的数据包最大长度（总长度字段的值），超过时返回错误，避免错误的数据包分配过大的内存
+const DefaultMaxFrameSize = 64 << 20
+
+// MsgReader 从一个连接连续读取数据包：带缓冲区读取（减少系统调用），固定长度部分读取到复用的 scratch 中
+type MsgReader struct {
+	r            *bufio.Reader
+	maxFrameSize int
+	scratch      [prefixLen]byte
+}
+
+// NewMsgReader maxFrameSize 数据包的最大长度，不大于0时使用 DefaultMaxFrameSize
+func NewMsgReader(r io.Reader, maxFrameSize int) *MsgReader {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
 	}
-	//******
-	err = binary.Write(w, binary.BigEndian, uint32(len(t.Payload))) // 7.写入 Payload 长度
-	if err != nil {
-		return err
+	if maxFrameSize <= 0 {
+		maxFrameSize = DefaultMaxFrameSize
 	}
-	_, err = w.Write(t.Payload) // 8.写入 Payload
+	return &MsgReader{r: br, maxFrameSize: maxFrameSize}
+}
 
-	return err
+// Recv 读取一个数据包，ObjectName、MethodName、Payload 直接引用数据包的数据（不复制）
+func (mr *MsgReader) Recv() (*RPCMsg, error) {
+	msg := NewRPCMsg()
+	if err := msg.recv(mr.r, mr.scratch[:], mr.maxFrameSize); err != nil {
+		return nil, err
+	}
+	return msg, nil
 }
 
-// RecvMsg 接收消息
+// RecvMsg 接收消息，数据包最大长度为 DefaultMaxFrameSize（连续读取同一个连接时使用 MsgReader）
 func (t *RPCMsg) RecvMsg(r io.Reader) error {
+	var scratch [prefixLen]byte
+	return t.recv(r, scratch[:], DefaultMaxFrameSize)
+}
 
-	var err error
-	//1. 读取header数据
-	_, err = io.ReadFull(r, t.Header[:])
+func (t *RPCMsg) recv(r io.Reader, scratch []byte, maxFrameSize int) error {
+	//1. 读取 header + seq + 总长度
+	_, err := io.ReadFull(r, scratch)
 	if err != nil {
 		return err
 	}
+	copy(t.Header[:], scratch[:HEADER_LEN])
 	if !t.Header.CheckMagicNumber() {
 		return fmt.Errorf("magic number error: %v", t.Header[0])
 	}
-	seqByte := make([]byte, 8)
-	_, err = io.ReadFull(r, seqByte)
-	if err != nil {
-		return err
+	t.Seq = int64(binary.BigEndian.Uint64(scratch[HEADER_LEN:]))
+	totalLen := binary.BigEndian.Uint32(scratch[HEADER_LEN+8:])
+	if uint64(totalLen) > uint64(maxFrameSize) {
+		return fmt.Errorf("%w: frame size %d exceeds %d", errBadFrame, totalLen, maxFrameSize)
 	}
-	t.Seq = int64(binary.BigEndian.Uint64(seqByte))
 
-	//2. 读取总长度
-	totalByte := make([]byte, 4)
-	_, err = io.ReadFull(r, totalByte)
-	if err != nil {
-		return err
-	}
-	totalLen := binary.BigEndian.Uint32(totalByte)
-	//3. 读取全部数据
+	//2. 读取全部数据（每个数据包单独分配，解析出的字段直接引用）
 	data := make([]byte, totalLen)
 	_, err = io.ReadFull(r, data)
 	if err != nil {
 		return err
 	}
+	return t.decodeBody(data)
+}
 
-	left, right := uint32(0), DATA_LEN
-	//4. 获取ObjectName
-	objectNameLen := binary.BigEndian.Uint32(data[left:right])
-
-	left = right
-	right = left + objectNameLen
-	t.ObjectName = utils.Bytes2String(data[left:right])
-
-	//5 .获取 MethodName
-	left = right
-	right = left + DATA_LEN
-	methodNameLen := binary.BigEndian.Uint32(data[left:right])
+var errBadFrame = errors.New("rpcmsg: malformed frame")
 
-	left = right
-	right = left + methodNameLen
-	t.MethodName = utils.Bytes2String(data[left:right])
+// decodeBody 解析总长度之后的数据
+func (t *RPCMsg) decodeBody(data []byte) error {
+	totalLen := uint32(len(data))
+	right := uint32(0)
+	// next 读取 uint32 长度 + 数据
+	next := func() ([]byte, error) {
+		if totalLen-right < DATA_LEN {
+			return nil, errBadFrame
+		}
+		n := binary.BigEndian.Uint32(data[right:])
+		left := right + DATA_LEN
+		if totalLen-left < n {
+			return nil, errBadFrame
+		}
+		right = left + n
+		return data[left:right], nil
+	}
 
-	// 6. 获取 Payload
+	//3. 获取ObjectName
+	objectName, err := next()
+	if err != nil {
+		return err
+	}
+	t.ObjectName = utils.Bytes2String(objectName)
 
-	left = right
-	right = left + DATA_LEN
+	//4. 获取 MethodName
+	methodName, err := next()
+	if err != nil {
+		return err
+	}
+	t.MethodName = utils.Bytes2String(methodName)
 
-	payLoadLen := binary.BigEndian.Uint32(data[left:right])
+	//5. 获取 Payload
+	t.Payload, err = next()
+	if err != nil {
+		return err
+	}
 
-	left = right
-	right = left + payLoadLen
+	//6. 获取 Timeout（旧版本的数据包没有该字段）
+	t.Timeout = 0
+	if totalLen-right >= TIMEOUT_LEN {
+		t.Timeout = time.Duration(binary.BigEndian.Uint64(data[right:]))
+		right += TIMEOUT_LEN
+	}
 
-	t.Payload = data[left:right]
+	//7. 获取 Error（没有错误并且没有Metadata时不存在该字段，长度为0表示没有错误）
+	t.Error = nil
+	if totalLen-right >= DATA_LEN {
+		errData, err := next()
+		if err != nil {
+			return err
+		}
+		if len(errData) > 0 {
+			t.Error, err = decodeError(errData)
+			if err != nil {
+				return err
+			}
+		}
+	}
 
-	return err
+	//8. 获取 Metadata（为空时不存在该字段）
+	t.Metadata = nil
+	if totalLen-right >= DATA_LEN {
+		mdData, err := next()
+		if err != nil {
+			return err
+		}
+		t.Metadata, err = decodeMetadata(mdData)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 type RPCMsgConfig struct {
@@ -154,6 +270,9 @@ type RPCMsgConfig struct {
 	ObjectName        string
 	MethodName        string
 	Seq               int64
+	Timeout           time.Duration
+	Error             *Error
+	Metadata          metadata.MD
 }
 
 func SendTo(w io.Writer, payload []byte, msgConfig RPCMsgConfig) error {
@@ -172,6 +291,9 @@ func SendTo(w io.Writer, payload []byte, msgConfig RPCMsgConfig) error {
 	msg.ObjectName = msgConfig.ObjectName
 	msg.MethodName = msgConfig.MethodName
 	msg.Payload = payload
+	msg.Timeout = msgConfig.Timeout
+	msg.Error = msgConfig.Error
+	msg.Metadata = msgConfig.Metadata
 	return msg.SendMsg(w)
 }
 
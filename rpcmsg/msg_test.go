@@ -2,9 +2,14 @@ package rpcmsg
 
 import (
 	"bytes"
+	"encoding/binary"
+	"io"
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/gofish2020/easyrpc/codec"
+	"github.com/gofish2020/easyrpc/metadata"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -22,7 +27,7 @@ func TestMsg(t *testing.T) {
 		"yu":   "fdsf",
 	})
 
-	compressor := Compressor[msg.CompressType()]
+	compressor := LookupCompressor(msg.CompressType())
 	compressPayload, _ := compressor.Compress(payload)
 	msg.Payload = compressPayload
 
@@ -48,3 +53,260 @@ func TestMsg(t *testing.T) {
 	err = json.Decode(unCompressPayload, &m)
 	t.Log(m, err)
 }
+
+func TestMsgTimeout(t *testing.T) {
+	msg := NewRPCMsg()
+	msg.ObjectName = "UserService"
+	msg.MethodName = "GetUserIds"
+	msg.Payload = []byte("payload")
+	msg.Timeout = 1500 * time.Millisecond
+
+	var buf bytes.Buffer
+	err := msg.SendMsg(&buf)
+	assert.Equal(t, nil, err)
+
+	msg2, err := RecvFrom(&buf)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, msg.Timeout, msg2.Timeout)
+	assert.Equal(t, msg.Payload, msg2.Payload)
+
+	// 旧版本数据包（没有Timeout字段）
+	buf.Reset()
+	msg.SendMsg(&buf)
+	data := buf.Bytes()
+	data = data[:len(data)-int(TIMEOUT_LEN)]
+	binary.BigEndian.PutUint32(data[13:17], binary.BigEndian.Uint32(data[13:17])-TIMEOUT_LEN)
+
+	msg3, err := RecvFrom(bytes.NewReader(data))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, time.Duration(0), msg3.Timeout)
+	assert.Equal(t, msg.MethodName, msg3.MethodName)
+}
+
+func TestMsgMetadata(t *testing.T) {
+	newMsg := func(version byte) *RPCMsg {
+		msg := NewRPCMsg()
+		msg.SetVersion(version)
+		msg.ObjectName = "UserService"
+		msg.MethodName = "GetUserIds"
+		msg.Payload = []byte("payload")
+		msg.Metadata = metadata.Pairs("trace-id", "abc", "user", "")
+		return msg
+	}
+
+	// 没有错误，只有 Metadata
+	var buf bytes.Buffer
+	assert.Equal(t, nil, newMsg(Version).SendMsg(&buf))
+	msg2, err := RecvFrom(&buf)
+	assert.Equal(t, nil, err)
+	assert.Nil(t, msg2.Error)
+	assert.Equal(t, metadata.MD{"trace-id": "abc", "user": ""}, msg2.Metadata)
+	assert.Equal(t, []byte("payload"), msg2.Payload)
+
+	// 错误和 Metadata
+	msg := newMsg(Version)
+	msg.Error = NewError(CodeInternal, "boom")
+	buf.Reset()
+	msg.SendMsg(&buf)
+	msg2, err = RecvFrom(&buf)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, msg.Error, msg2.Error)
+	assert.Equal(t, msg.Metadata, msg2.Metadata)
+
+	// 对方是旧版本：不发送 Metadata，数据包和旧版本一致
+	buf.Reset()
+	newMsg(0x01).SendMsg(&buf)
+	assert.Equal(t, HEADER_LEN+8+4+4+11+4+10+4+7+int(TIMEOUT_LEN), buf.Len())
+	msg2, err = RecvFrom(&buf)
+	assert.Equal(t, nil, err)
+	assert.Nil(t, msg2.Metadata)
+}
+
+func TestCompressThreshold(t *testing.T) {
+	small := []byte("small")
+	large := bytes.Repeat([]byte("large payload "), 100)
+
+	// 小于阈值时不压缩
+	typ, data, err := Compress(Snappy, 64, small)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, None, typ)
+	assert.Equal(t, small, data)
+
+	typ, data, err = Compress(Snappy, 64, large)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, Snappy, typ)
+	assert.Less(t, len(data), len(large))
+	res, err := UnCompress(typ, data)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, large, res)
+
+	// None 原样返回
+	typ, data, err = Compress(None, 0, large)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, None, typ)
+	assert.Equal(t, large, data)
+
+	_, _, err = Compress(CompressType(100), 0, large)
+	assert.NotNil(t, err)
+	_, err = UnCompress(CompressType(100), large)
+	assert.NotNil(t, err)
+}
+
+// countWriter 记录 Write 调用次数
+type countWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.Buffer.Write(p)
+}
+
+func TestMsgFrame(t *testing.T) {
+	newMsg := func(payload []byte) *RPCMsg {
+		msg := NewRPCMsg()
+		msg.SetVersion(Version)
+		msg.Seq = 7
+		msg.ObjectName = "UserService"
+		msg.MethodName = "GetUserIds"
+		msg.Payload = payload
+		msg.Timeout = time.Second
+		msg.Error = NewError(CodeInternal, "boom")
+		msg.Metadata = metadata.Pairs("trace-id", "abc")
+		return msg
+	}
+
+	// 整个数据包一次写入
+	var w countWriter
+	assert.Equal(t, nil, newMsg([]byte("payload")).SendMsg(&w))
+	assert.Equal(t, 1, w.writes)
+
+	// 较大的 Payload 不复制，分开写入
+	large := bytes.Repeat([]byte("x"), largePayloadSize+1)
+	assert.Equal(t, nil, newMsg(large).SendMsg(&w))
+	assert.Equal(t, 4, w.writes)
+
+	// 同一个连接连续读取
+	reader := NewMsgReader(&w, 0)
+	for _, payload := range [][]byte{[]byte("payload"), large} {
+		msg, err := reader.Recv()
+		assert.Equal(t, nil, err)
+		expected := newMsg(payload)
+		assert.Equal(t, expected.Header, msg.Header)
+		assert.Equal(t, expected.Seq, msg.Seq)
+		assert.Equal(t, expected.ObjectName, msg.ObjectName)
+		assert.Equal(t, expected.MethodName, msg.MethodName)
+		assert.Equal(t, expected.Payload, msg.Payload)
+		assert.Equal(t, expected.Timeout, msg.Timeout)
+		assert.Equal(t, expected.Error, msg.Error)
+		assert.Equal(t, expected.Metadata, msg.Metadata)
+	}
+	_, err := reader.Recv()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestMalformedFrame(t *testing.T) {
+	var buf bytes.Buffer
+	msg := NewRPCMsg()
+	msg.ObjectName = "UserService"
+	msg.MethodName = "GetUserIds"
+	msg.Payload = []byte("payload")
+	msg.SendMsg(&buf)
+	frame := buf.Bytes()
+
+	// ObjectName 长度超过总长度
+	bad := append([]byte(nil), frame...)
+	binary.BigEndian.PutUint32(bad[prefixLen:], 1000)
+	_, err := RecvFrom(bytes.NewReader(bad))
+	assert.Equal(t, errBadFrame, err)
+
+	// 总长度不足以包含 Payload 长度
+	bad = append([]byte(nil), frame[:prefixLen+4+11+4+10]...)
+	binary.BigEndian.PutUint32(bad[HEADER_LEN+8:], 4+11+4+10)
+	_, err = RecvFrom(bytes.NewReader(bad))
+	assert.Equal(t, errBadFrame, err)
+
+	// 数据不完整
+	_, err = RecvFrom(bytes.NewReader(frame[:len(frame)-1]))
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	// Metadata 的个数超过数据长度，不分配内存直接返回错误
+	mdMsg := NewRPCMsg()
+	mdMsg.SetVersion(Version)
+	mdMsg.Metadata = metadata.Pairs("k", "v")
+	var mdBuf bytes.Buffer
+	mdMsg.SendMsg(&mdBuf)
+	bad = mdBuf.Bytes()
+	binary.BigEndian.PutUint32(bad[len(bad)-(4+4+1+4+1):], 50000000)
+	_, err = RecvFrom(bytes.NewReader(bad))
+	assert.Equal(t, errBadMetadata, err)
+
+	// 总长度超过最大长度，不分配内存直接返回错误
+	bad = append([]byte(nil), frame[:prefixLen]...)
+	binary.BigEndian.PutUint32(bad[HEADER_LEN+8:], 0xFFFFFFFF)
+	_, err = RecvFrom(bytes.NewReader(bad))
+	assert.ErrorIs(t, err, errBadFrame)
+
+	_, err = NewMsgReader(bytes.NewReader(frame), len(frame)-prefixLen-1).Recv()
+	assert.ErrorIs(t, err, errBadFrame)
+	msg2, err := NewMsgReader(bytes.NewReader(frame), len(frame)-prefixLen).Recv()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, msg.Payload, msg2.Payload)
+}
+
+func benchmarkMsg(payloadSize int) *RPCMsg {
+	msg := NewRPCMsg()
+	msg.SetMsgType(Request)
+	msg.SetVersion(Version)
+	msg.Seq = 1
+	msg.ObjectName = "UserService"
+	msg.MethodName = "GetUserIds"
+	msg.Payload = bytes.Repeat([]byte("x"), payloadSize)
+	msg.Timeout = time.Second
+	msg.Metadata = metadata.Pairs("trace-id", "abc")
+	return msg
+}
+
+func BenchmarkSendMsg(b *testing.B) {
+	for _, size := range []int{64, 4 << 10, 64 << 10} {
+		msg := benchmarkMsg(size)
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := msg.SendMsg(io.Discard); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// repeatReader 重复读取同一个数据包
+type repeatReader struct {
+	frame []byte
+	off   int
+}
+
+func (r *repeatReader) Read(p []byte) (int, error) {
+	n := copy(p, r.frame[r.off:])
+	r.off = (r.off + n) % len(r.frame)
+	return n, nil
+}
+
+func BenchmarkRecvMsg(b *testing.B) {
+	for _, size := range []int{64, 4 << 10, 64 << 10} {
+		var buf bytes.Buffer
+		benchmarkMsg(size).SendMsg(&buf)
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			reader := NewMsgReader(&repeatReader{frame: buf.Bytes()}, 0)
+			b.ReportAllocs()
+			b.SetBytes(int64(buf.Len()))
+			for i := 0; i < b.N; i++ {
+				if _, err := reader.Recv(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -1,17 +1,137 @@
 package rpcmsg
 
 import (
+	"fmt"
+	"sync"
+
 	"github.com/gofish2020/easyrpc/codec"
 	"github.com/gofish2020/easyrpc/compress"
 )
 
-var Codecs = map[SerializeType]codec.Codec{
-	Gob:  codec.GobCodec{},
-	Json: codec.GobCodec{},
+// 小于该值的序列化类型/压缩类型由框架保留，自定义的类型从这里开始
+const (
+	SerializeTypeUserBase SerializeType = 0x80
+	CompressTypeUserBase  CompressType  = 0x80
+)
+
+var (
+	codecMu sync.RWMutex
+	codecs  = map[SerializeType]codec.Codec{
+		Gob:      codec.GobCodec{},
+		Json:     codec.JsonCodec{},
+		Protobuf: codec.ProtobufCodec{},
+		MsgPack:  codec.MsgPackCodec{},
+	}
+
+	compressorMu sync.RWMutex
+	compressors  = map[CompressType]compress.Compression{
+		None:   compress.GetNoneCompresser(),
+		Snappy: compress.GetSnappyCompresser(),
+		Zlib:   compress.GetZlibCompresser(),
+		Lz4:    compress.GetLz4Compresser(),
+		Zstd:   compress.GetZstdCompresser(),
+		Gzip:   compress.GetGzipCompresser(),
+	}
+)
+
+// Codecs 内置的序列化器
+//
+// Deprecated: 只包含内置类型，修改不会生效；使用 LookupCodec 和 RegisterCodec
+var Codecs = func() map[SerializeType]codec.Codec {
+	m := make(map[SerializeType]codec.Codec, len(codecs))
+	for k, v := range codecs {
+		m[k] = v
+	}
+	return m
+}()
+
+// Compressor 内置的压缩器
+//
+// Deprecated: 只包含内置类型，修改不会生效；使用 LookupCompressor 和 RegisterCompressor
+var Compressor = func() map[CompressType]compress.Compression {
+	m := make(map[CompressType]compress.Compression, len(compressors))
+	for k, v := range compressors {
+		m[k] = v
+	}
+	return m
+}()
+
+// RegisterCodec 注册自定义的序列化类型，服务端和客户端需要注册相同的类型
+// 类型必须不小于 SerializeTypeUserBase，重复注册会 panic
+func RegisterCodec(serializeType SerializeType, c codec.Codec) {
+	if serializeType < SerializeTypeUserBase {
+		panic(fmt.Sprintf("rpcmsg: serialize type %d is reserved", serializeType))
+	}
+	if c == nil {
+		panic(fmt.Sprintf("rpcmsg: register nil codec for serialize type %d", serializeType))
+	}
+	codecMu.Lock()
+	defer codecMu.Unlock()
+	if _, ok := codecs[serializeType]; ok {
+		panic(fmt.Sprintf("rpcmsg: serialize type %d is registered", serializeType))
+	}
+	codecs[serializeType] = c
+}
+
+// LookupCodec 序列化类型对应的序列化器，未注册返回nil
+func LookupCodec(serializeType SerializeType) codec.Codec {
+	codecMu.RLock()
+	defer codecMu.RUnlock()
+	return codecs[serializeType]
+}
+
+// RegisterCompressor 注册自定义的压缩类型，服务端和客户端需要注册相同的类型
+// 类型必须不小于 CompressTypeUserBase，重复注册会 panic
+func RegisterCompressor(compressType CompressType, c compress.Compression) {
+	if compressType < CompressTypeUserBase {
+		panic(fmt.Sprintf("rpcmsg: compress type %d is reserved", compressType))
+	}
+	if c == nil {
+		panic(fmt.Sprintf("rpcmsg: register nil compressor for compress type %d", compressType))
+	}
+	compressorMu.Lock()
+	defer compressorMu.Unlock()
+	if _, ok := compressors[compressType]; ok {
+		panic(fmt.Sprintf("rpcmsg: compress type %d is registered", compressType))
+	}
+	compressors[compressType] = c
+}
+
+// LookupCompressor 压缩类型对应的压缩器，未注册返回nil
+func LookupCompressor(compressType CompressType) compress.Compression {
+	compressorMu.RLock()
+	defer compressorMu.RUnlock()
+	return compressors[compressType]
+}
+
+// Compress 按照 compressType 压缩数据，数据小于 threshold 字节时不压缩
+// 返回实际使用的压缩类型，用于设置数据包头
+func Compress(compressType CompressType, threshold int, data []byte) (CompressType, []byte, error) {
+	return CompressWith(nil, compressType, threshold, data)
+}
+
+// CompressWith 和 Compress 相同，优先使用 overrides 中的压缩器
+// 用于发送方设置内置类型的压缩级别（接收方解压缩不受影响）
+func CompressWith(overrides map[CompressType]compress.Compression, compressType CompressType, threshold int, data []byte) (CompressType, []byte, error) {
+	if len(data) < threshold {
+		compressType = None
+	}
+	compressor := overrides[compressType]
+	if compressor == nil {
+		compressor = LookupCompressor(compressType)
+	}
+	if compressor == nil {
+		return compressType, nil, fmt.Errorf("unsupported compress type %d (not registered)", compressType)
+	}
+	data, err := compressor.Compress(data)
+	return compressType, data, err
 }
 
-var Compressor = map[CompressType]compress.Compression{
-	Snappy: compress.GetSnappyCompresser(),
-	Zlib:   compress.GetZlibCompresser(),
-	Lz4:    compress.GetLz4Compresser(),
+// UnCompress 按照数据包头的压缩类型解压缩
+func UnCompress(compressType CompressType, data []byte) ([]byte, error) {
+	compressor := LookupCompressor(compressType)
+	if compressor == nil {
+		return nil, fmt.Errorf("unsupported compress type %d (not registered)", compressType)
+	}
+	return compressor.UnCompress(data)
 }
@@ -0,0 +1,159 @@
+package rpcmsg
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"github.com/gofish2020/easyrpc/codec"
+	"github.com/gofish2020/easyrpc/compress"
+	"github.com/stretchr/testify/assert"
+)
+
+// reverse 自定义的压缩器：字节逆序
+type reverse struct{}
+
+func (t reverse) Compress(data []byte) ([]byte, error) {
+	res := make([]byte, len(data))
+	for i := range data {
+		res[len(data)-1-i] = data[i]
+	}
+	return res, nil
+}
+
+func (t reverse) UnCompress(data []byte) ([]byte, error) {
+	return t.Compress(data)
+}
+
+// unregisterCodec 删除注册的序列化类型，测试结束时清理（保证测试可以重复执行）
+func unregisterCodec(serializeType SerializeType) {
+	codecMu.Lock()
+	defer codecMu.Unlock()
+	delete(codecs, serializeType)
+}
+
+func unregisterCompressor(compressType CompressType) {
+	compressorMu.Lock()
+	defer compressorMu.Unlock()
+	delete(compressors, compressType)
+}
+
+func TestRegisterCodec(t *testing.T) {
+	assert.Nil(t, LookupCodec(SerializeTypeUserBase))
+	t.Cleanup(func() {
+		for i := 0; i < 10; i++ {
+			unregisterCodec(SerializeTypeUserBase + SerializeType(i))
+		}
+	})
+
+	// 并发注册不同的类型
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			RegisterCodec(SerializeTypeUserBase+SerializeType(i), codec.JsonCodec{})
+			assert.NotNil(t, LookupCodec(Gob))
+		}(i)
+	}
+	wg.Wait()
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, codec.JsonCodec{}, LookupCodec(SerializeTypeUserBase+SerializeType(i)))
+	}
+
+	// 框架保留的类型、重复注册、nil
+	assert.Panics(t, func() { RegisterCodec(Json, codec.JsonCodec{}) })
+	assert.Panics(t, func() { RegisterCodec(SerializeTypeUserBase-1, codec.JsonCodec{}) })
+	assert.Panics(t, func() { RegisterCodec(SerializeTypeUserBase, codec.GobCodec{}) })
+	assert.Panics(t, func() { RegisterCodec(SerializeTypeUserBase+100, nil) })
+}
+
+func TestRegisterCompressor(t *testing.T) {
+	typ := CompressTypeUserBase + 1
+	_, err := UnCompress(typ, []byte("abc"))
+	assert.NotNil(t, err)
+
+	RegisterCompressor(typ, reverse{})
+	t.Cleanup(func() { unregisterCompressor(typ) })
+	realTyp, data, err := Compress(typ, 0, []byte("abc"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, typ, realTyp)
+	assert.Equal(t, []byte("cba"), data)
+
+	// 自定义的压缩类型随数据包发送
+	msg := NewRPCMsg()
+	msg.SetCompressType(realTyp)
+	msg.Payload = data
+	var buf bytes.Buffer
+	assert.Equal(t, nil, msg.SendMsg(&buf))
+	msg2, err := RecvFrom(&buf)
+	assert.Equal(t, nil, err)
+	res, err := UnCompress(msg2.CompressType(), msg2.Payload)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []byte("abc"), res)
+
+	assert.Panics(t, func() { RegisterCompressor(Snappy, reverse{}) })
+	assert.Panics(t, func() { RegisterCompressor(typ, reverse{}) })
+	assert.Panics(t, func() { RegisterCompressor(typ+1, nil) })
+}
+
+func TestBuiltinCompressors(t *testing.T) {
+	data := bytes.Repeat([]byte("payload "), 100)
+	for _, typ := range []CompressType{None, Zlib, Snappy, Lz4, Zstd, Gzip} {
+		realTyp, compressRes, err := Compress(typ, 0, data)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, typ, realTyp)
+		res, err := UnCompress(typ, compressRes)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, data, res)
+	}
+}
+
+func TestDeprecatedMaps(t *testing.T) {
+	// 兼容旧代码：包含内置类型，与 Lookup 的结果相同
+	for typ, c := range Codecs {
+		assert.Equal(t, LookupCodec(typ), c)
+	}
+	assert.Equal(t, 4, len(Codecs))
+	for typ, c := range Compressor {
+		assert.Equal(t, LookupCompressor(typ), c)
+	}
+	assert.Equal(t, 6, len(Compressor))
+}
+
+// countCompressor 记录压缩的次数
+type countCompressor struct {
+	compress.Compression
+	count int
+}
+
+func (c *countCompressor) Compress(data []byte) ([]byte, error) {
+	c.count++
+	return c.Compression.Compress(data)
+}
+
+func TestCompressWith(t *testing.T) {
+	data := bytes.Repeat([]byte("payload "), 100)
+	zlib := &countCompressor{Compression: compress.GetZlibCompresser(compress.WithLevel(9))}
+	overrides := map[CompressType]compress.Compression{
+		Zlib: zlib,
+		Zstd: compress.GetZstdCompresser(compress.WithLevel(19)),
+	}
+
+	// 设置了压缩级别的压缩器，使用注册的压缩器解压缩
+	for _, typ := range []CompressType{Zlib, Zstd, Snappy} {
+		realTyp, compressRes, err := CompressWith(overrides, typ, 0, data)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, typ, realTyp)
+		res, err := UnCompress(typ, compressRes)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, data, res)
+	}
+	assert.Equal(t, 1, zlib.count)
+
+	// 小于阈值时不压缩
+	realTyp, _, err := CompressWith(overrides, Zlib, len(data)+1, data)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, None, realTyp)
+	assert.Equal(t, 1, zlib.count)
+}
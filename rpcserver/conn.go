@@ -0,0 +1,106 @@
+package rpcserver
+
+import (
+	"context"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gofish2020/easyrpc/rpcmsg"
+)
+
+// serverConn 服务端的一个客户端连接：请求并发处理，响应通过 send 串行写入
+type serverConn struct {
+	conn   net.Conn
+	option Option
+
+	wmu sync.Mutex // 写入的并发控制
+
+	mu       sync.Mutex // inflight/closing 的并发控制
+	inflight int        // 处理中的请求个数
+	closing  bool       // 已经通知客户端服务关闭，请求处理完成后关闭连接
+
+	sem chan struct{} // 连接的并发请求限制，nil表示不限制
+
+	ctx    context.Context // 请求ctx的父ctx，连接读取结束时取消
+	cancel context.CancelFunc
+}
+
+func newServerConn(conn net.Conn, option Option) *serverConn {
+	sc := &serverConn{
+		conn:   conn,
+		option: option,
+	}
+	sc.ctx, sc.cancel = context.WithCancel(context.Background())
+	sc.sem = newSem(option.MaxConcurrentPerConn, DefaultOption.MaxConcurrentPerConn)
+	sc.resetReadDeadline()
+	return sc
+}
+
+// resetReadDeadline 连接空闲（没有处理中的请求）超过 ReadTimeout 则关闭
+func (sc *serverConn) resetReadDeadline() {
+	if sc.option.ReadTimeout != 0 {
+		sc.conn.SetReadDeadline(time.Now().Add(sc.option.ReadTimeout))
+	}
+}
+
+// begin 开始处理一个请求，有处理中的请求时连接不算空闲
+func (sc *serverConn) begin() {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.inflight++
+	if sc.option.ReadTimeout != 0 {
+		sc.conn.SetReadDeadline(time.Time{})
+	}
+}
+
+// end 请求处理完成
+func (sc *serverConn) end() {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.inflight--
+	if sc.inflight == 0 {
+		if sc.closing {
+			sc.conn.Close()
+			return
+		}
+		sc.resetReadDeadline()
+	}
+}
+
+// inflightCount 处理中的请求个数
+func (sc *serverConn) inflightCount() int {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.inflight
+}
+
+// goAway 通知客户端服务即将关闭，空闲的连接直接关闭
+func (sc *serverConn) goAway() {
+	err := sc.send(nil, rpcmsg.RPCMsgConfig{
+		MsgTypeConf: rpcmsg.GoAway,
+		VersionConf: rpcmsg.Version,
+	})
+	if err != nil {
+		log.Printf("send goaway to %s error:%+v\n", sc.conn.RemoteAddr().String(), err)
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.closing = true
+	if sc.inflight == 0 {
+		sc.conn.Close()
+	}
+}
+
+// send 发送一个数据包
+func (sc *serverConn) send(payload []byte, config rpcmsg.RPCMsgConfig) error {
+	sc.wmu.Lock()
+	defer sc.wmu.Unlock()
+	// 写超时时间
+	if sc.option.WriteTimeout != 0 {
+		sc.conn.SetWriteDeadline(time.Now().Add(sc.option.WriteTimeout))
+	}
+	return rpcmsg.SendTo(sc.conn, payload, config)
+}
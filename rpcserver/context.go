@@ -0,0 +1,18 @@
+package rpcserver
+
+import (
+	"context"
+	"net"
+)
+
+type peerKey struct{}
+
+func newPeerContext(ctx context.Context, addr net.Addr) context.Context {
+	return context.WithValue(ctx, peerKey{}, addr)
+}
+
+// PeerFromContext 获取请求的客户端地址，方法的第一个参数是 context.Context 时可以使用
+func PeerFromContext(ctx context.Context) (net.Addr, bool) {
+	addr, ok := ctx.Value(peerKey{}).(net.Addr)
+	return addr, ok
+}
@@ -1,23 +1,128 @@
 package rpcserver
 
-import "reflect"
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/gofish2020/easyrpc/rpcmsg"
+)
 
 type Handler interface {
-	Handle(string, []interface{}) ([]interface{}, error)
+	// ArgTypes 方法的入参类型，用于解码入参（不包括第一个参数 context.Context）
+	ArgTypes(string) ([]reflect.Type, error)
+	// Handle 执行方法，方法的第一个参数是 context.Context 时传入ctx
+	Handle(context.Context, string, []interface{}) ([]interface{}, error)
+}
+
+var (
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+)
+
+// methodType 注册时记录的方法信息
+type methodType struct {
+	method   reflect.Value
+	withCtx  bool           // 第一个参数是 context.Context，由框架传入，客户端不发送
+	variadic bool           // 可变参数，最后一个入参是切片
+	argTypes []reflect.Type // 入参类型
 }
 
 type RPCHandler struct {
-	object reflect.Value
+	object  reflect.Value
+	methods map[string]*methodType
+}
+
+// NewRPCHandler 收集对象可以被调用的方法：导出的方法，且最后一个返回值为 error
+// 方法的第一个参数可以是 context.Context：包含请求的超时时间、metadata、客户端地址，客户端断开连接时取消
+func NewRPCHandler(obj interface{}) (*RPCHandler, error) {
+	if obj == nil {
+		return nil, fmt.Errorf("register nil object")
+	}
+	handler := &RPCHandler{
+		object:  reflect.ValueOf(obj),
+		methods: make(map[string]*methodType),
+	}
+
+	objType := handler.object.Type()
+	for i := 0; i < objType.NumMethod(); i++ {
+		method := objType.Method(i)
+		if !method.IsExported() {
+			continue
+		}
+		// 最后一个返回值必须是 error
+		mType := method.Type
+		if mType.NumOut() == 0 || mType.Out(mType.NumOut()-1) != errorType {
+			continue
+		}
+		// 第0个参数是接收者
+		start := 1
+		withCtx := mType.NumIn() > 1 && mType.In(1) == contextType
+		if withCtx {
+			start = 2
+		}
+		argTypes := make([]reflect.Type, 0, mType.NumIn()-start)
+		for j := start; j < mType.NumIn(); j++ {
+			argTypes = append(argTypes, mType.In(j))
+		}
+		handler.methods[method.Name] = &methodType{
+			method:   handler.object.Method(i),
+			withCtx:  withCtx,
+			variadic: mType.IsVariadic(),
+			argTypes: argTypes,
+		}
+	}
+
+	if len(handler.methods) == 0 {
+		return nil, fmt.Errorf("type %s has no exported methods whose last return value is error", objType)
+	}
+	return handler, nil
+}
+
+func (handler *RPCHandler) ArgTypes(methodName string) ([]reflect.Type, error) {
+	mType, ok := handler.methods[methodName]
+	if !ok {
+		return nil, rpcmsg.NewError(rpcmsg.CodeMethodNotFound, "method %s not found", methodName)
+	}
+	return mType.argTypes, nil
 }
 
-func (handler *RPCHandler) Handle(methodName string, params []interface{}) ([]interface{}, error) {
-	argsIn := make([]reflect.Value, len(params))
+func (handler *RPCHandler) Handle(ctx context.Context, methodName string, params []interface{}) ([]interface{}, error) {
+	mType, ok := handler.methods[methodName]
+	if !ok {
+		return nil, rpcmsg.NewError(rpcmsg.CodeMethodNotFound, "method %s not found", methodName)
+	}
+
+	// 检查入参个数和类型
+	if len(params) != len(mType.argTypes) {
+		return nil, rpcmsg.NewError(rpcmsg.CodeInvalidArgument, "method %s needs %d arguments, got %d", methodName, len(mType.argTypes), len(params))
+	}
+	argsIn := make([]reflect.Value, 0, len(params)+1)
+	if mType.withCtx {
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		argsIn = append(argsIn, reflect.ValueOf(ctx))
+	}
 	for i := range params {
-		argsIn[i] = reflect.ValueOf(params[i])
+		argType := mType.argTypes[i]
+		if params[i] == nil {
+			argsIn = append(argsIn, reflect.Zero(argType))
+			continue
+		}
+		arg := reflect.ValueOf(params[i])
+		if !arg.Type().AssignableTo(argType) {
+			return nil, rpcmsg.NewError(rpcmsg.CodeInvalidArgument, "method %s argument %d needs %s, got %s", methodName, i, argType, arg.Type())
+		}
+		argsIn = append(argsIn, arg)
 	}
 
-	method := handler.object.MethodByName(methodName)
-	argsOut := method.Call(argsIn)
+	var argsOut []reflect.Value
+	if mType.variadic {
+		argsOut = mType.method.CallSlice(argsIn)
+	} else {
+		argsOut = mType.method.Call(argsIn)
+	}
 
 	result := make([]interface{}, len(argsOut))
 	for i := range argsOut {
@@ -0,0 +1,122 @@
+package rpcserver
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/gofish2020/easyrpc/rpcmsg"
+	"github.com/stretchr/testify/assert"
+)
+
+type mathService struct{}
+
+func (t *mathService) Add(a, b int) (int, error) {
+	return a + b, nil
+}
+
+// 最后一个返回值不是 error，不能被调用
+func (t *mathService) Sub(a, b int) int {
+	return a - b
+}
+
+func (t *mathService) add(a, b int) (int, error) {
+	return a + b, nil
+}
+
+type ctxKey struct{}
+
+// 第一个参数是 context.Context
+func (t *mathService) Mul(ctx context.Context, a, b int) (int, error) {
+	if ctx.Value(ctxKey{}) == nil {
+		return 0, errors.New("no context value")
+	}
+	return a * b, nil
+}
+
+type noMethodService struct{}
+
+func (t noMethodService) Name() string {
+	return "none"
+}
+
+func TestNewRPCHandler(t *testing.T) {
+	handler, err := NewRPCHandler(&mathService{})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(handler.methods))
+	assert.Equal(t, 2, len(handler.methods["Add"].argTypes))
+	// context.Context 不是需要解码的入参
+	argTypes, err := handler.ArgTypes("Mul")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(argTypes))
+
+	_, err = NewRPCHandler(noMethodService{})
+	assert.NotNil(t, err)
+
+	_, err = NewRPCHandler(nil)
+	assert.NotNil(t, err)
+}
+
+func TestHandle(t *testing.T) {
+	handler, _ := NewRPCHandler(&mathService{})
+
+	result, err := handler.Handle(context.Background(), "Add", []interface{}{1, 2})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []interface{}{3, nil}, result)
+
+	_, err = handler.Handle(context.Background(), "Sub", []interface{}{1, 2})
+	assert.True(t, errors.Is(err, rpcmsg.ErrMethodNotFound))
+
+	_, err = handler.Handle(context.Background(), "Add", []interface{}{1})
+	assert.True(t, errors.Is(err, rpcmsg.ErrInvalidArgument))
+
+	_, err = handler.Handle(context.Background(), "Add", []interface{}{1, "2"})
+	assert.True(t, errors.Is(err, rpcmsg.ErrInvalidArgument))
+}
+
+func TestRegister(t *testing.T) {
+	server := NewRPCServer(DefaultOption)
+	assert.Equal(t, nil, server.Register(&mathService{}))
+	assert.NotNil(t, server.Register(&mathService{}))
+	assert.NotNil(t, server.RegisterByName("None", noMethodService{}))
+}
+
+func TestHandleContext(t *testing.T) {
+	handler, _ := NewRPCHandler(&mathService{})
+
+	ctx := context.WithValue(context.Background(), ctxKey{}, 1)
+	result, err := handler.Handle(ctx, "Mul", []interface{}{2, 3})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []interface{}{6, nil}, result)
+
+	_, err = handler.Handle(context.Background(), "Mul", []interface{}{2, 3})
+	assert.EqualError(t, err, "no context value")
+}
+
+type sumService struct{}
+
+// 可变参数：客户端发送一个切片
+func (sumService) Sum(ctx context.Context, nums ...int) (int, error) {
+	total := 0
+	for _, n := range nums {
+		total += n
+	}
+	return total, nil
+}
+
+func TestHandleVariadic(t *testing.T) {
+	handler, err := NewRPCHandler(sumService{})
+	assert.Equal(t, nil, err)
+
+	result, err := handler.Handle(context.Background(), "Sum", []interface{}{[]int{1, 2, 3}})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []interface{}{6, nil}, result)
+
+	// 没有可变参数
+	result, err = handler.Handle(context.Background(), "Sum", []interface{}{nil})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []interface{}{0, nil}, result)
+
+	_, err = handler.Handle(context.Background(), "Sum", []interface{}{1})
+	assert.True(t, errors.Is(err, rpcmsg.ErrInvalidArgument))
+}
@@ -0,0 +1,29 @@
+package rpcserver
+
+import "context"
+
+// CallInfo 拦截器可以获取的请求信息
+type CallInfo struct {
+	ObjectName string
+	MethodName string
+	RemoteAddr string
+}
+
+// HandleFunc 执行方法，results 不包括最后的error
+type HandleFunc func(ctx context.Context, args []interface{}) (results []interface{}, err error)
+
+// Interceptor 服务端拦截器：args 是解码后的入参，可以在 next 前后增加逻辑（日志、鉴权、限流等），
+// 也可以不调用 next 直接返回
+type Interceptor func(ctx context.Context, info *CallInfo, args []interface{}, next HandleFunc) ([]interface{}, error)
+
+// chainInterceptors 按照顺序组合拦截器，第一个拦截器在最外层
+func chainInterceptors(interceptors []Interceptor, info *CallInfo, final HandleFunc) HandleFunc {
+	handle := final
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], handle
+		handle = func(ctx context.Context, args []interface{}) ([]interface{}, error) {
+			return interceptor(ctx, info, args, next)
+		}
+	}
+	return handle
+}
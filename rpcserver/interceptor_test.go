@@ -0,0 +1,110 @@
+package rpcserver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gofish2020/easyrpc/metadata"
+	"github.com/gofish2020/easyrpc/rpcmsg"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInterceptors(t *testing.T) {
+	var order []string
+	trace := func(name string) Interceptor {
+		return func(ctx context.Context, info *CallInfo, args []interface{}, next HandleFunc) ([]interface{}, error) {
+			order = append(order, name+" before "+info.ObjectName+"."+info.MethodName)
+			results, err := next(ctx, args)
+			order = append(order, name+" after")
+			return results, err
+		}
+	}
+	errDenied := errors.New("denied")
+	auth := func(ctx context.Context, info *CallInfo, args []interface{}, next HandleFunc) ([]interface{}, error) {
+		if info.MethodName == "Sleep" {
+			return nil, errDenied
+		}
+		// 修改入参和结果
+		args[0] = strings.ToUpper(args[0].(string))
+		results, err := next(ctx, args)
+		if err == nil {
+			results[0] = results[0].(string) + "!"
+		}
+		return results, err
+	}
+
+	listen := NewRPCListener(Option{Interceptors: []Interceptor{trace("first"), trace("second"), auth}})
+	handler, _ := NewRPCHandler(&echoService{})
+	listen.SetHandler("Echo", handler)
+
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	go listen.handleConn(serverConn)
+
+	sendEcho(t, clientConn, "hello")
+	msg, err := rpcmsg.RecvFrom(clientConn)
+	assert.Equal(t, nil, err)
+	assert.Nil(t, msg.Error)
+	payload, err := rpcmsg.LookupCompressor(rpcmsg.Snappy).UnCompress(msg.Payload)
+	assert.Equal(t, nil, err)
+	results, err := rpcmsg.DecodeArgs(rpcmsg.LookupCodec(rpcmsg.Gob), payload, []reflect.Type{reflect.TypeOf("")})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "HELLO!", results[0].String())
+	assert.Equal(t, []string{"first before Echo.Echo", "second before Echo.Echo", "second after", "first after"}, order)
+
+	// 拦截器直接返回错误，方法不执行
+	sendRequest(t, clientConn, "Echo", "Sleep", 2, encodeArgs(t, 1000))
+	msg, err = rpcmsg.RecvFrom(clientConn)
+	assert.Equal(t, nil, err)
+	if assert.NotNil(t, msg.Error) {
+		assert.Equal(t, "denied", msg.Error.Message)
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	// 拦截器读取客户端的 metadata，并通过 trailer 返回
+	echoMetadata := func(ctx context.Context, info *CallInfo, args []interface{}, next HandleFunc) ([]interface{}, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		if err := metadata.SetTrailer(ctx, metadata.Pairs("trace-id", md.Get("trace-id"))); err != nil {
+			return nil, err
+		}
+		return next(ctx, args)
+	}
+	listen := NewRPCListener(Option{Interceptors: []Interceptor{echoMetadata}})
+	handler, _ := NewRPCHandler(&echoService{})
+	listen.SetHandler("Echo", handler)
+
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	go listen.handleConn(serverConn)
+
+	send := func(version byte) *rpcmsg.RPCMsg {
+		err := rpcmsg.SendTo(clientConn, encodeArgs(t, "hello"), rpcmsg.RPCMsgConfig{
+			MsgTypeConf:       rpcmsg.Request,
+			CompressTypeConf:  rpcmsg.Snappy,
+			SerializeTypeConf: rpcmsg.Gob,
+			VersionConf:       version,
+			ObjectName:        "Echo",
+			MethodName:        "Echo",
+			Seq:               1,
+			Metadata:          metadata.Pairs("trace-id", "abc"),
+		})
+		assert.Equal(t, nil, err)
+		msg, err := rpcmsg.RecvFrom(clientConn)
+		assert.Equal(t, nil, err)
+		assert.Nil(t, msg.Error)
+		assert.Equal(t, version, msg.Version())
+		return msg
+	}
+
+	msg := send(rpcmsg.Version)
+	assert.Equal(t, metadata.MD{"trace-id": "abc"}, msg.Metadata)
+
+	// 旧版本的客户端：不发送 metadata，也不会收到 trailer
+	msg = send(0x01)
+	assert.Nil(t, msg.Metadata)
+}
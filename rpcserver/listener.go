@@ -1,31 +1,49 @@
 package rpcserver
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net"
+	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/gofish2020/easyrpc/metadata"
 	"github.com/gofish2020/easyrpc/rpcmsg"
 )
 
 type Listener interface {
 	Run()
-	Shutdown()
-	SetHandler(string, Handler)
+	Shutdown(context.Context) error
+	SetHandler(string, Handler) error
 }
 
 func NewRPCListener(option Option) *RPCListener {
-	return &RPCListener{
+	listen := &RPCListener{
 		Ip:        option.Ip,
 		Port:      option.Port,
 		option:    option,
 		Handlers:  make(map[string]Handler),
 		shutdown:  0,
-		running:   0,
+		conns:     make(map[*serverConn]struct{}),
+		drained:   make(chan struct{}),
 		closechan: make(chan struct{}),
 	}
+	listen.sem = newSem(option.MaxConcurrent, DefaultOption.MaxConcurrent)
+	return listen
+}
+
+// newSem 并发上限为0时使用默认上限，负数表示不限制（返回nil）
+func newSem(limit, defaultLimit int) chan struct{} {
+	if limit == 0 {
+		limit = defaultLimit
+	}
+	if limit < 0 {
+		return nil
+	}
+	return make(chan struct{}, limit)
 }
 
 type RPCListener struct {
@@ -36,8 +54,12 @@ type RPCListener struct {
 
 	l net.Listener
 
-	running  int32 // 运行中的连接
-	shutdown int32 // 服务关闭标识
+	mu       sync.Mutex               // conns 的并发控制
+	conns    map[*serverConn]struct{} // 运行中的连接
+	shutdown int32                    // 服务关闭标识
+	drained  chan struct{}            // 关闭中，所有连接都已经关闭
+
+	sem chan struct{} // 服务的并发请求限制，nil表示不限制
 
 	closechan chan struct{} // 监听关闭
 }
@@ -45,6 +67,35 @@ type RPCListener struct {
 func (listen *RPCListener) isShutDonw() bool {
 	return atomic.LoadInt32(&listen.shutdown) == 1
 }
+
+// addConn 记录连接，服务关闭中返回false
+func (listen *RPCListener) addConn(sc *serverConn) bool {
+	listen.mu.Lock()
+	defer listen.mu.Unlock()
+	if listen.isShutDonw() {
+		return false
+	}
+	listen.conns[sc] = struct{}{}
+	return true
+}
+
+func (listen *RPCListener) removeConn(sc *serverConn) {
+	listen.mu.Lock()
+	defer listen.mu.Unlock()
+	delete(listen.conns, sc)
+	if listen.isShutDonw() && len(listen.conns) == 0 {
+		listen.closeDrained()
+	}
+}
+
+func (listen *RPCListener) closeDrained() {
+	select {
+	case <-listen.drained:
+	default:
+		close(listen.drained)
+	}
+}
+
 func (listen *RPCListener) Run() {
 	addr := fmt.Sprintf("%s:%d", listen.Ip, listen.Port)
 	l, err := net.Listen("tcp", addr)
@@ -83,11 +134,13 @@ func (listen *RPCListener) acceptConn() {
 
 // 客户端连接处理
 func (listen *RPCListener) handleConn(conn net.Conn) {
+	sc := newServerConn(conn, listen.option)
 	// 如果服务正在关闭中...新连接进来自动关闭
-	if listen.isShutDonw() {
+	if !listen.addConn(sc) {
 		conn.Close()
 		return
 	}
+	defer listen.removeConn(sc)
 
 	log.Printf("new client connection come in %s\n", conn.RemoteAddr().String())
 	// 避免 panic
@@ -98,106 +151,263 @@ func (listen *RPCListener) handleConn(conn net.Conn) {
 		conn.Close()
 
 	}()
-	// 记录处理中的连接个数
-	atomic.AddInt32(&listen.running, 1)
-	defer func() {
-		atomic.AddInt32(&listen.running, -1)
-	}()
-	// 服务度是否关闭
-	for !listen.isShutDonw() {
 
-		// 读超时时间
-		// if listen.option.ReadTimeout != 0 {
-		// 	conn.SetReadDeadline(time.Now().Add(listen.option.ReadTimeout))
-		// }
+	// 关闭连接前，等待处理中的请求完成
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	// 读取结束（客户端断开连接等），取消处理中的请求
+	defer sc.cancel()
 
+	// 服务关闭时，连接在处理中的请求完成后关闭（读取随之结束）
+	reader := rpcmsg.NewMsgReader(conn, listen.option.MaxFrameSize)
+	for {
 		// 从连接冲接收一个完整的数据包
-		msg, err := rpcmsg.RecvFrom(conn)
+		msg, err := reader.Recv()
 		if err != nil {
+			if e, ok := err.(net.Error); ok && e.Timeout() {
+				log.Printf("addr %s idle timeout, close connection\n", conn.RemoteAddr().String())
+				return
+			}
 			log.Printf("receive msg error:%+v\n", err)
 			return
 		}
 
-		startTime := time.Now()
-		// 压缩器
-		compressor := rpcmsg.Compressor[msg.Header.CompressType()]
-		payload, err := compressor.UnCompress(msg.Payload)
-		if err != nil {
-			log.Printf("uncompress msg error; %+v\n", err)
-			return
+		sc.begin()
+		// 服务关闭中，客户端在收到 GoAway 之前发送的请求不再执行
+		if listen.isShutDonw() {
+			err = listen.sendError(sc, msg, rpcmsg.NewError(rpcmsg.CodeUnavailable, "server is shutting down"))
+			sc.end()
+			if err != nil {
+				return
+			}
+			continue
 		}
-		// 序列化器
-		codeTool := rpcmsg.Codecs[msg.Header.SerializeType()]
+		// 并发数达到上限时阻塞在这里，不再读取新的请求
+		listen.acquire(sc)
+		wg.Add(1)
+		go func(msg *rpcmsg.RPCMsg) {
+			defer func() {
+				if err := recover(); err != nil {
+					log.Printf("addr %s panic err :%+v\n", conn.RemoteAddr().String(), err)
+				}
+				listen.release(sc)
+				sc.end()
+				wg.Done()
+			}()
+			if err := listen.handleMsg(sc, msg); err != nil {
+				conn.Close() // 发送失败，关闭连接（读取也会随之结束）
+			}
+		}(msg)
+	}
+}
 
-		// 入参解码
-		argsIn := make([]interface{}, 0)
-		err = codeTool.Decode(payload, &argsIn)
-		if err != nil {
-			log.Printf("decode msg error; %+v\n", err)
-			return
-		}
-		// 并行读 Handlers是安全的
-		handler, ok := listen.Handlers[msg.ObjectName]
-		if !ok {
-			log.Printf("%s is't registered!\n", msg.ObjectName)
-			return
-		}
-		// 执行对象的具体方法
-		result, err := handler.Handle(msg.MethodName, argsIn)
-		if err != nil {
-			log.Printf("%s.%s func exec error(可忽略错误)\n", msg.ObjectName, msg.MethodName)
-		}
-		// 编码结果
-		encodeRes, err := codeTool.Encode(result)
-		if err != nil {
-			log.Printf("encode msg error:%+v\n", err)
-			return
-		}
-		// 压缩结果
-		compressRes, err := compressor.Compress(encodeRes)
-		if err != nil {
-			log.Printf("compress msg error:%+v\n", err)
-			return
-		}
+// acquire 获取连接和服务的并发请求名额
+func (listen *RPCListener) acquire(sc *serverConn) {
+	if sc.sem != nil {
+		sc.sem <- struct{}{}
+	}
+	if listen.sem != nil {
+		listen.sem <- struct{}{}
+	}
+}
 
-		// 写超时时间
+func (listen *RPCListener) release(sc *serverConn) {
+	if listen.sem != nil {
+		<-listen.sem
+	}
+	if sc.sem != nil {
+		<-sc.sem
+	}
+}
+
+// handleMsg 处理一个请求，请求本身的错误通过响应返回给客户端，只有发送失败时返回错误（关闭连接）
+func (listen *RPCListener) handleMsg(sc *serverConn, msg *rpcmsg.RPCMsg) error {
+	startTime := time.Now()
+	// 客户端断开连接时取消；客户端设置了超时时间，超时后放弃处理
+	ctx := newPeerContext(sc.ctx, sc.conn.RemoteAddr())
+	if msg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, msg.Timeout)
+		defer cancel()
+	}
+	// 客户端发送的 metadata，以及方法通过 metadata.SetTrailer 设置的 trailer
+	ctx = metadata.NewIncomingContext(ctx, msg.Metadata)
+	var trailer metadata.MD
+	ctx = metadata.WithTrailer(ctx, &trailer)
+	// 按照请求数据包头的压缩类型解压缩
+	// 没有注册的压缩类型/序列化类型，返回错误
+	if rpcmsg.LookupCompressor(msg.CompressType()) == nil {
+		return listen.sendError(sc, msg, rpcmsg.NewError(rpcmsg.CodeBadRequest, "unsupported compress type %d (not registered)", msg.CompressType()))
+	}
+	payload, err := rpcmsg.UnCompress(msg.CompressType(), msg.Payload)
+	if err != nil {
+		log.Printf("uncompress msg error; %+v\n", err)
+		return listen.sendError(sc, msg, rpcmsg.NewError(rpcmsg.CodeBadRequest, "uncompress msg error: %v", err))
+	}
+	// 序列化器
+	codeTool := rpcmsg.LookupCodec(msg.Header.SerializeType())
+	if codeTool == nil {
+		return listen.sendError(sc, msg, rpcmsg.NewError(rpcmsg.CodeBadRequest, "unsupported serialize type %d (not registered)", msg.Header.SerializeType()))
+	}
 
-		if listen.option.WriteTimeout != 0 {
-			conn.SetWriteDeadline(time.Now().Add(listen.option.WriteTimeout))
+	// 并行读 Handlers是安全的
+	handler, ok := listen.Handlers[msg.ObjectName]
+	if !ok {
+		log.Printf("%s is't registered!\n", msg.ObjectName)
+		return listen.sendError(sc, msg, rpcmsg.NewError(rpcmsg.CodeServiceNotFound, "%s is't registered", msg.ObjectName))
+	}
+	argTypes, err := handler.ArgTypes(msg.MethodName)
+	if err != nil {
+		return listen.sendError(sc, msg, rpcmsg.ToError(err))
+	}
+	// 入参按照方法的参数类型解码
+	args, err := rpcmsg.DecodeArgs(codeTool, payload, argTypes)
+	if err != nil {
+		log.Printf("decode msg error; %+v\n", err)
+		if errors.Is(err, rpcmsg.ErrArgsMismatch) {
+			return listen.sendError(sc, msg, rpcmsg.NewError(rpcmsg.CodeInvalidArgument, "%s.%s %v", msg.ObjectName, msg.MethodName, err))
 		}
-		config := rpcmsg.RPCMsgConfig{
-			MsgTypeConf:       rpcmsg.Response,
-			CompressTypeConf:  msg.CompressType(),
-			SerializeTypeConf: msg.SerializeType(),
-			VersionConf:       msg.Version(),
-			Seq:               msg.Seq,
-			ObjectName:        "",
-			MethodName:        "",
+		return listen.sendError(sc, msg, rpcmsg.NewError(rpcmsg.CodeBadRequest, "decode msg error: %v", err))
+	}
+	argsIn := make([]interface{}, len(args))
+	for i := range args {
+		argsIn[i] = args[i].Interface()
+	}
+	// 调用方已经放弃等待，不再执行
+	if ctx.Err() != nil {
+		log.Printf("%s.%s abandoned: %+v\n", msg.ObjectName, msg.MethodName, ctx.Err())
+		return nil
+	}
+	// 经过拦截器，执行对象的具体方法
+	info := &CallInfo{
+		ObjectName: msg.ObjectName,
+		MethodName: msg.MethodName,
+		RemoteAddr: sc.conn.RemoteAddr().String(),
+	}
+	result, callErr := listen.call(ctx, info, handler, argsIn)
+	if callErr != nil {
+		log.Printf("%s.%s func exec error:%+v\n", msg.ObjectName, msg.MethodName, callErr)
+	}
+	// 执行期间调用方已经超时，不再返回结果
+	if ctx.Err() != nil {
+		log.Printf("%s.%s abandoned after %d ms: %+v\n", msg.ObjectName, msg.MethodName, time.Since(startTime).Milliseconds(), ctx.Err())
+		return nil
+	}
+	// 方法返回错误：不返回其他结果，错误信息放在响应数据包的 Error 字段
+	if callErr != nil {
+		return listen.sendResponse(sc, msg, msg.CompressType(), nil, rpcmsg.ToError(callErr), trailer)
+	}
+
+	// 编码结果
+	encodeRes, err := rpcmsg.EncodeArgs(codeTool, result)
+	if err != nil {
+		log.Printf("encode msg error:%+v\n", err)
+		return listen.sendError(sc, msg, rpcmsg.NewError(rpcmsg.CodeInternal, "encode result error: %v", err))
+	}
+	// 压缩结果：和请求使用相同的压缩类型，小于 CompressThreshold 时不压缩
+	compressType, compressRes, err := rpcmsg.CompressWith(listen.option.Compressors, msg.CompressType(), listen.option.CompressThreshold, encodeRes)
+	if err != nil {
+		log.Printf("compress msg error:%+v\n", err)
+		return listen.sendError(sc, msg, rpcmsg.NewError(rpcmsg.CodeInternal, "compress result error: %v", err))
+	}
+
+	// 将结果返回给客户端
+	err = listen.sendResponse(sc, msg, compressType, compressRes, nil, trailer)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("%s.%s total runtime %d ms\n", msg.ObjectName, msg.MethodName, time.Since(startTime).Milliseconds())
+	return nil
+}
+
+// call 经过拦截器执行方法，返回的结果不包括最后的error，方法或者拦截器panic时返回 CodeInternal 错误
+func (listen *RPCListener) call(ctx context.Context, info *CallInfo, handler Handler, argsIn []interface{}) (result []interface{}, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			log.Printf("%s panic err :%+v\n", info.MethodName, e)
+			result, err = nil, rpcmsg.NewError(rpcmsg.CodeInternal, "%s panic: %v", info.MethodName, e)
 		}
-		// 将结果返回给客户端
-		err = rpcmsg.SendTo(conn, compressRes, config)
+	}()
+	handle := chainInterceptors(listen.option.Interceptors, info, func(ctx context.Context, args []interface{}) ([]interface{}, error) {
+		result, err := handler.Handle(ctx, info.MethodName, args)
 		if err != nil {
-			log.Printf("send msg error:%+v\n", err)
-			return
+			return nil, err
 		}
+		// 最后一个返回值error为nil，不需要返回
+		return result[:len(result)-1], nil
+	})
+	return handle(ctx, argsIn)
+}
+
+// sendError 返回错误响应（不压缩，没有Payload）
+func (listen *RPCListener) sendError(sc *serverConn, msg *rpcmsg.RPCMsg, rpcErr *rpcmsg.Error) error {
+	return listen.sendResponse(sc, msg, msg.CompressType(), nil, rpcErr, nil)
+}
 
-		log.Printf("%s.%s total runtime %d ms\n", msg.ObjectName, msg.MethodName, time.Since(startTime).Milliseconds())
+// sendResponse 返回响应，Seq和协议版本和请求保持一致（旧版本的客户端不会收到 trailer）
+// compressType 是 payload 实际使用的压缩类型
+func (listen *RPCListener) sendResponse(sc *serverConn, msg *rpcmsg.RPCMsg, compressType rpcmsg.CompressType, payload []byte, rpcErr *rpcmsg.Error, trailer metadata.MD) error {
+	config := rpcmsg.RPCMsgConfig{
+		MsgTypeConf:       rpcmsg.Response,
+		CompressTypeConf:  compressType,
+		SerializeTypeConf: msg.SerializeType(),
+		VersionConf:       msg.Version(),
+		Seq:               msg.Seq,
+		ObjectName:        "",
+		MethodName:        "",
+		Error:             rpcErr,
+		Metadata:          trailer,
 	}
+	err := sc.send(payload, config)
+	if err != nil {
+		log.Printf("send msg error:%+v\n", err)
+	}
+	return err
 }
-func (listen *RPCListener) Shutdown() {
+
+// Shutdown 优雅关闭：停止监听，通知所有客户端（GoAway），空闲的连接直接关闭，
+// 其余连接在处理中的请求完成后关闭；ctx结束时强制关闭剩余的连接，返回被放弃的请求个数
+func (listen *RPCListener) Shutdown(ctx context.Context) error {
 	// 设置关闭标识
+	listen.mu.Lock()
 	atomic.CompareAndSwapInt32(&listen.shutdown, 0, 1)
+	conns := make([]*serverConn, 0, len(listen.conns))
+	for sc := range listen.conns {
+		conns = append(conns, sc)
+	}
+	if len(conns) == 0 {
+		listen.closeDrained()
+	}
+	listen.mu.Unlock()
+
 	// 关闭监听
 	listen.closeChan()
 	if listen.l != nil {
 		listen.l.Close()
 	}
-	// 说明还有连接在处理（自旋锁）
-	for atomic.LoadInt32(&listen.running) != 0 {
-		//log.Printf("还有 %d task running\n", listen.running)
+
+	// 通知客户端
+	for _, sc := range conns {
+		go sc.goAway()
+	}
+
+	select {
+	case <-listen.drained:
+		log.Printf("server shutdown success!!!\n")
+		return nil
+	case <-ctx.Done():
 	}
-	log.Printf("server shutdown success!!!\n")
 
+	// 超时，强制关闭剩余的连接
+	listen.mu.Lock()
+	abandoned := 0
+	for sc := range listen.conns {
+		abandoned += sc.inflightCount()
+		sc.conn.Close()
+	}
+	listen.mu.Unlock()
+	return fmt.Errorf("server shutdown: %w, %d calls abandoned", ctx.Err(), abandoned)
 }
 
 func (listen *RPCListener) closeChan() {
@@ -207,10 +417,10 @@ func (listen *RPCListener) closeChan() {
 		close(listen.closechan)
 	}
 }
-func (listen *RPCListener) SetHandler(objectName string, handler Handler) {
+func (listen *RPCListener) SetHandler(objectName string, handler Handler) error {
 	if _, ok := listen.Handlers[objectName]; ok {
-		log.Printf("objectName %s is registered!\n", objectName)
-		return
+		return fmt.Errorf("objectName %s is registered", objectName)
 	}
 	listen.Handlers[objectName] = handler
+	return nil
 }
@@ -0,0 +1,451 @@
+package rpcserver
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gofish2020/easyrpc/compress"
+	"github.com/gofish2020/easyrpc/metadata"
+	"github.com/gofish2020/easyrpc/rpcmsg"
+	"github.com/stretchr/testify/assert"
+)
+
+type echoService struct{}
+
+func (t *echoService) Echo(s string) (string, error) {
+	return s, nil
+}
+
+func (t *echoService) Sleep(ms int) (int, error) {
+	time.Sleep(time.Duration(ms) * time.Millisecond)
+	return ms, nil
+}
+
+// Context 返回方法可以从ctx中获取的请求信息
+func (t *echoService) Context(ctx context.Context, key string) (string, error) {
+	_, hasDeadline := ctx.Deadline()
+	md, _ := metadata.FromIncomingContext(ctx)
+	addr, _ := PeerFromContext(ctx)
+	return fmt.Sprintf("%v %s %s", hasDeadline, md.Get(key), addr.Network()), nil
+}
+
+// Wait 等待请求被取消
+func (t *echoService) Wait(ctx context.Context) (string, error) {
+	<-ctx.Done()
+	canceled <- ctx.Err()
+	return "", ctx.Err()
+}
+
+var canceled = make(chan error, 1)
+
+func newTestListener(option Option) *RPCListener {
+	listen := NewRPCListener(option)
+	handler, _ := NewRPCHandler(&echoService{})
+	listen.SetHandler("Echo", handler)
+	return listen
+}
+
+func sendRequest(t *testing.T, conn net.Conn, objectName, methodName string, seq int64, payload []byte) {
+	err := rpcmsg.SendTo(conn, payload, rpcmsg.RPCMsgConfig{
+		MsgTypeConf:       rpcmsg.Request,
+		CompressTypeConf:  rpcmsg.Snappy,
+		SerializeTypeConf: rpcmsg.Gob,
+		VersionConf:       rpcmsg.Version,
+		ObjectName:        objectName,
+		MethodName:        methodName,
+		Seq:               seq,
+	})
+	assert.Equal(t, nil, err)
+}
+
+func encodeArgs(t *testing.T, args ...interface{}) []byte {
+	payload, err := rpcmsg.EncodeArgs(rpcmsg.LookupCodec(rpcmsg.Gob), args)
+	assert.Equal(t, nil, err)
+	payload, err = rpcmsg.LookupCompressor(rpcmsg.Snappy).Compress(payload)
+	assert.Equal(t, nil, err)
+	return payload
+}
+
+func sendEcho(t *testing.T, conn net.Conn, s string) {
+	sendRequest(t, conn, "Echo", "Echo", 1, encodeArgs(t, s))
+}
+
+func TestReadTimeoutClosesIdleConn(t *testing.T) {
+	listen := newTestListener(Option{ReadTimeout: 50 * time.Millisecond})
+
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	done := make(chan struct{})
+	go func() {
+		listen.handleConn(serverConn)
+		close(done)
+	}()
+
+	// 客户端不发送任何数据
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("idle connection is not closed")
+	}
+	_, err := clientConn.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestMaxFrameSize(t *testing.T) {
+	listen := newTestListener(Option{MaxFrameSize: 64})
+
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	done := make(chan struct{})
+	go func() {
+		listen.handleConn(serverConn)
+		close(done)
+	}()
+
+	// 超过最大长度的请求，关闭连接
+	go rpcmsg.SendTo(clientConn, make([]byte, 1024), rpcmsg.RPCMsgConfig{
+		MsgTypeConf: rpcmsg.Request,
+		VersionConf: rpcmsg.Version,
+		ObjectName:  "Echo",
+		MethodName:  "Echo",
+	})
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("connection with oversized frame is not closed")
+	}
+}
+
+func TestReadTimeoutKeepsActiveConn(t *testing.T) {
+	listen := newTestListener(Option{ReadTimeout: 100 * time.Millisecond})
+
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	done := make(chan struct{})
+	go func() {
+		listen.handleConn(serverConn)
+		close(done)
+	}()
+
+	// 请求间隔小于 ReadTimeout，连接保持可用
+	for i := 0; i < 5; i++ {
+		time.Sleep(50 * time.Millisecond)
+		sendEcho(t, clientConn, "hello")
+		resMsg, err := rpcmsg.RecvFrom(clientConn)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, rpcmsg.Response, resMsg.MsgType())
+	}
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("idle connection is not closed")
+	}
+}
+
+func TestErrorResponseKeepsConn(t *testing.T) {
+	listen := newTestListener(Option{})
+
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	go listen.handleConn(serverConn)
+
+	cases := []struct {
+		objectName string
+		methodName string
+		payload    []byte
+		code       rpcmsg.Code
+	}{
+		{"Unknown", "Echo", encodeArgs(t, "hello"), rpcmsg.CodeServiceNotFound},
+		{"Echo", "Unknown", encodeArgs(t, "hello"), rpcmsg.CodeMethodNotFound},
+		{"Echo", "Echo", []byte("not snappy"), rpcmsg.CodeBadRequest},
+		{"Echo", "Echo", encodeArgs(t, 1, 2), rpcmsg.CodeInvalidArgument},
+		{"Echo", "Echo", encodeArgs(t, 1), rpcmsg.CodeInvalidArgument},
+	}
+	for i, c := range cases {
+		seq := int64(i + 100)
+		sendRequest(t, clientConn, c.objectName, c.methodName, seq, c.payload)
+		resMsg, err := rpcmsg.RecvFrom(clientConn)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, seq, resMsg.Seq)
+		if assert.NotNil(t, resMsg.Error) {
+			assert.Equal(t, c.code, resMsg.Error.Code, resMsg.Error.Message)
+		}
+	}
+
+	// 连接仍然可用
+	sendEcho(t, clientConn, "hello")
+	resMsg, err := rpcmsg.RecvFrom(clientConn)
+	assert.Equal(t, nil, err)
+	assert.Nil(t, resMsg.Error)
+}
+
+func TestMethodContext(t *testing.T) {
+	listen := newTestListener(Option{})
+
+	serverConn, clientConn := net.Pipe()
+	go listen.handleConn(serverConn)
+
+	err := rpcmsg.SendTo(clientConn, encodeArgs(t, "trace-id"), rpcmsg.RPCMsgConfig{
+		MsgTypeConf:       rpcmsg.Request,
+		CompressTypeConf:  rpcmsg.Snappy,
+		SerializeTypeConf: rpcmsg.Gob,
+		VersionConf:       rpcmsg.Version,
+		ObjectName:        "Echo",
+		MethodName:        "Context",
+		Seq:               1,
+		Timeout:           time.Second,
+		Metadata:          metadata.Pairs("trace-id", "abc"),
+	})
+	assert.Equal(t, nil, err)
+	msg, err := rpcmsg.RecvFrom(clientConn)
+	assert.Equal(t, nil, err)
+	assert.Nil(t, msg.Error)
+	payload, _ := rpcmsg.LookupCompressor(rpcmsg.Snappy).UnCompress(msg.Payload)
+	results, err := rpcmsg.DecodeArgs(rpcmsg.LookupCodec(rpcmsg.Gob), payload, []reflect.Type{reflect.TypeOf("")})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "true abc pipe", results[0].String())
+
+	// 客户端断开连接，处理中的请求被取消
+	sendRequest(t, clientConn, "Echo", "Wait", 2, encodeArgs(t))
+	time.Sleep(20 * time.Millisecond)
+	clientConn.Close()
+	select {
+	case err = <-canceled:
+		assert.Equal(t, context.Canceled, err)
+	case <-time.After(time.Second):
+		t.Fatal("request is not canceled")
+	}
+}
+
+func TestUnsupportedCodec(t *testing.T) {
+	listen := newTestListener(Option{})
+
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	go listen.handleConn(serverConn)
+
+	cases := []struct {
+		compressType  rpcmsg.CompressType
+		serializeType rpcmsg.SerializeType
+		message       string
+	}{
+		{rpcmsg.CompressTypeUserBase + 10, rpcmsg.Gob, "unsupported compress type 138 (not registered)"},
+		{rpcmsg.None, rpcmsg.SerializeTypeUserBase + 10, "unsupported serialize type 138 (not registered)"},
+	}
+	for _, c := range cases {
+		err := rpcmsg.SendTo(clientConn, []byte("payload"), rpcmsg.RPCMsgConfig{
+			MsgTypeConf:       rpcmsg.Request,
+			CompressTypeConf:  c.compressType,
+			SerializeTypeConf: c.serializeType,
+			VersionConf:       rpcmsg.Version,
+			ObjectName:        "Echo",
+			MethodName:        "Echo",
+			Seq:               1,
+		})
+		assert.Equal(t, nil, err)
+		msg, err := rpcmsg.RecvFrom(clientConn)
+		assert.Equal(t, nil, err)
+		if assert.NotNil(t, msg.Error) {
+			assert.Equal(t, rpcmsg.CodeBadRequest, msg.Error.Code)
+			assert.Equal(t, c.message, msg.Error.Message)
+		}
+	}
+}
+
+// 请求的响应顺序
+func responseOrder(t *testing.T, option Option) []int64 {
+	listen := newTestListener(option)
+
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	go listen.handleConn(serverConn)
+
+	sendRequest(t, clientConn, "Echo", "Sleep", 1, encodeArgs(t, 200))
+	sendRequest(t, clientConn, "Echo", "Sleep", 2, encodeArgs(t, 0))
+
+	seqs := make([]int64, 0, 2)
+	for i := 0; i < 2; i++ {
+		resMsg, err := rpcmsg.RecvFrom(clientConn)
+		assert.Equal(t, nil, err)
+		assert.Nil(t, resMsg.Error)
+		seqs = append(seqs, resMsg.Seq)
+	}
+	return seqs
+}
+
+func TestConcurrentRequests(t *testing.T) {
+	// 慢请求不会阻塞同一个连接上的其他请求
+	assert.Equal(t, []int64{2, 1}, responseOrder(t, Option{MaxConcurrentPerConn: 2}))
+	// 达到并发上限后，请求依次处理
+	assert.Equal(t, []int64{1, 2}, responseOrder(t, Option{MaxConcurrentPerConn: 1}))
+	assert.Equal(t, []int64{1, 2}, responseOrder(t, Option{MaxConcurrent: 1}))
+	// 负数表示不限制
+	assert.Equal(t, []int64{2, 1}, responseOrder(t, Option{MaxConcurrentPerConn: -1, MaxConcurrent: -1}))
+}
+
+func TestConcurrentLimitDefault(t *testing.T) {
+	// 没有设置上限时使用 DefaultOption 的上限
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	listen := NewRPCListener(Option{})
+	assert.Equal(t, DefaultOption.MaxConcurrent, cap(listen.sem))
+	sc := newServerConn(serverConn, Option{})
+	assert.Equal(t, DefaultOption.MaxConcurrentPerConn, cap(sc.sem))
+
+	listen = NewRPCListener(Option{MaxConcurrent: -1})
+	assert.Nil(t, listen.sem)
+	sc = newServerConn(serverConn, Option{MaxConcurrentPerConn: -1})
+	assert.Nil(t, sc.sem)
+}
+
+func TestShutdownIdleConn(t *testing.T) {
+	listen := newTestListener(Option{})
+
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	go listen.handleConn(serverConn)
+	time.Sleep(10 * time.Millisecond)
+
+	done := make(chan error)
+	go func() {
+		done <- listen.Shutdown(context.Background())
+	}()
+
+	msg, err := rpcmsg.RecvFrom(clientConn)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, rpcmsg.GoAway, msg.MsgType())
+	_, err = rpcmsg.RecvFrom(clientConn)
+	assert.Equal(t, io.EOF, err)
+
+	select {
+	case err = <-done:
+		assert.Equal(t, nil, err)
+	case <-time.After(time.Second):
+		t.Fatal("shutdown blocked by idle connection")
+	}
+}
+
+func TestShutdownDrain(t *testing.T) {
+	listen := newTestListener(Option{})
+
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	go listen.handleConn(serverConn)
+
+	sendRequest(t, clientConn, "Echo", "Sleep", 1, encodeArgs(t, 200))
+	time.Sleep(20 * time.Millisecond)
+
+	done := make(chan error)
+	go func() {
+		done <- listen.Shutdown(context.Background())
+	}()
+
+	msg, err := rpcmsg.RecvFrom(clientConn)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, rpcmsg.GoAway, msg.MsgType())
+
+	// GoAway 之后的请求不再执行
+	sendEcho(t, clientConn, "hello")
+	msg, err = rpcmsg.RecvFrom(clientConn)
+	assert.Equal(t, nil, err)
+	if assert.NotNil(t, msg.Error) {
+		assert.Equal(t, rpcmsg.CodeUnavailable, msg.Error.Code)
+	}
+
+	// 处理中的请求正常返回，之后连接关闭
+	msg, err = rpcmsg.RecvFrom(clientConn)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(1), msg.Seq)
+	assert.Nil(t, msg.Error)
+	_, err = rpcmsg.RecvFrom(clientConn)
+	assert.Equal(t, io.EOF, err)
+
+	assert.Equal(t, nil, <-done)
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	listen := newTestListener(Option{})
+
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	go listen.handleConn(serverConn)
+	go io.Copy(io.Discard, clientConn)
+
+	sendRequest(t, clientConn, "Echo", "Sleep", 1, encodeArgs(t, 500))
+	time.Sleep(20 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := listen.Shutdown(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Contains(t, err.Error(), "1 calls abandoned")
+}
+
+// countCompressor 记录压缩的次数
+type countCompressor struct {
+	compress.Compression
+	count int32
+}
+
+func (c *countCompressor) Compress(data []byte) ([]byte, error) {
+	atomic.AddInt32(&c.count, 1)
+	return c.Compression.Compress(data)
+}
+
+func TestCompressThreshold(t *testing.T) {
+	// 替换 Snappy 的压缩器（设置压缩级别），客户端使用注册的压缩器解压缩
+	snappy := &countCompressor{Compression: compress.GetSnappyCompresser(compress.WithLevel(2))}
+	listen := newTestListener(Option{
+		CompressThreshold: 64,
+		Compressors:       map[rpcmsg.CompressType]compress.Compression{rpcmsg.Snappy: snappy},
+	})
+
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	go listen.handleConn(serverConn)
+
+	send := func(compressType rpcmsg.CompressType, s string) string {
+		payload, err := rpcmsg.EncodeArgs(rpcmsg.LookupCodec(rpcmsg.Gob), []interface{}{s})
+		assert.Equal(t, nil, err)
+		_, payload, err = rpcmsg.Compress(compressType, 0, payload)
+		assert.Equal(t, nil, err)
+		err = rpcmsg.SendTo(clientConn, payload, rpcmsg.RPCMsgConfig{
+			MsgTypeConf:       rpcmsg.Request,
+			CompressTypeConf:  compressType,
+			SerializeTypeConf: rpcmsg.Gob,
+			VersionConf:       rpcmsg.Version,
+			ObjectName:        "Echo",
+			MethodName:        "Echo",
+			Seq:               1,
+		})
+		assert.Equal(t, nil, err)
+		msg, err := rpcmsg.RecvFrom(clientConn)
+		assert.Equal(t, nil, err)
+		assert.Nil(t, msg.Error)
+		// 响应按照数据包头的压缩类型解压缩
+		payload, err = rpcmsg.UnCompress(msg.CompressType(), msg.Payload)
+		assert.Equal(t, nil, err)
+		results, err := rpcmsg.DecodeArgs(rpcmsg.LookupCodec(rpcmsg.Gob), payload, []reflect.Type{reflect.TypeOf("")})
+		assert.Equal(t, nil, err)
+		assert.Equal(t, s, results[0].String())
+		return fmt.Sprint(msg.CompressType())
+	}
+
+	large := strings.Repeat("hello", 100)
+	// 请求不压缩
+	assert.Equal(t, fmt.Sprint(rpcmsg.None), send(rpcmsg.None, large))
+	// 小于阈值的结果不压缩
+	assert.Equal(t, fmt.Sprint(rpcmsg.None), send(rpcmsg.Snappy, "hello"))
+	assert.Equal(t, fmt.Sprint(rpcmsg.Snappy), send(rpcmsg.Snappy, large))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&snappy.count))
+}
@@ -1,58 +1,176 @@
 package rpcserver
 
 import (
+	"context"
+	"fmt"
+	"log"
 	"reflect"
+	"sync"
 	"time"
+
+	"github.com/gofish2020/easyrpc/compress"
+	"github.com/gofish2020/easyrpc/registry"
+	"github.com/gofish2020/easyrpc/rpcmsg"
 )
 
 type Server interface {
-	Register(string, interface{})
+	Register(interface{}) error
+	RegisterByName(string, interface{}) error
 	Run()
-	Shutdown()
+	Shutdown(context.Context) error
 }
 
 // 服务启动配置参数
 type Option struct {
 	Ip           string
 	Port         int
-	ReadTimeout  time.Duration
+	ReadTimeout  time.Duration // 连接空闲（没有收到请求）超过该时间则关闭连接
 	WriteTimeout time.Duration
+
+	MaxConcurrentPerConn int // 每个连接同时处理的请求上限，0表示 DefaultOption 的上限，负数表示不限制
+	MaxConcurrent        int // 服务同时处理的请求上限，0表示 DefaultOption 的上限，负数表示不限制
+
+	CompressThreshold int // 编码后的结果小于该字节数时不压缩（数据包头的压缩类型为 None），0表示总是压缩
+	// 替换压缩类型的压缩器，只用于压缩响应（例如设置压缩级别），zstd 字典需要注册自定义类型
+	Compressors map[rpcmsg.CompressType]compress.Compression
+
+	MaxFrameSize int // 请求数据包的最大长度，超过时关闭连接，0表示 rpcmsg.DefaultMaxFrameSize
+
+	Registry      registry.Registry // 服务注册：Run 时注册所有对象名，Shutdown 时先注销再关闭连接
+	RegisterTTL   time.Duration     // 注册的存活时间，每 TTL/3 续期一次，0表示不过期
+	Metadata      map[string]string // 注册的实例信息，例如 version、weight、zone
+	AdvertiseAddr string            // 注册的地址，默认 Ip:Port
+
+	Interceptors []Interceptor // 拦截器，按照顺序执行
 }
 
 var DefaultOption = Option{
-	ReadTimeout:  5 * time.Second,
-	WriteTimeout: 5 * time.Second,
+	ReadTimeout:          5 * time.Second,
+	WriteTimeout:         5 * time.Second,
+	MaxConcurrentPerConn: 128,
+	MaxConcurrent:        4096,
 }
 
 func NewRPCServer(option Option) *RPCServer {
 	return &RPCServer{
-		listener: NewRPCListener(option),
-		option:   option,
+		listener:      NewRPCListener(option),
+		option:        option,
+		stopHeartbeat: make(chan struct{}),
 	}
 }
 
 type RPCServer struct {
 	listener Listener
 	option   Option
+
+	objectNames    []string            // 注册的对象名
+	instances      []registry.Instance // 注册到注册中心的实例
+	stopHeartbeat  chan struct{}
+	heartbeatWg    sync.WaitGroup
+	deregisterOnce sync.Once
 }
 
-func (server *RPCServer) Register(obj interface{}) {
+// Register 注册对象，对象类型名作为 ObjectName
+func (server *RPCServer) Register(obj interface{}) error {
+	if obj == nil {
+		return fmt.Errorf("register nil object")
+	}
 	objectName := reflect.Indirect(reflect.ValueOf(obj)).Type().Name()
-	server.RegisterByName(objectName, obj)
-
+	return server.RegisterByName(objectName, obj)
 }
 
-func (server *RPCServer) RegisterByName(objectName string, obj interface{}) {
-	server.listener.SetHandler(objectName, &RPCHandler{object: reflect.ValueOf(obj)})
+// RegisterByName 注册对象，对象没有可以调用的方法或者 objectName 重复注册时返回错误
+func (server *RPCServer) RegisterByName(objectName string, obj interface{}) error {
+	if objectName == "" {
+		return fmt.Errorf("register object without name")
+	}
+	handler, err := NewRPCHandler(obj)
+	if err != nil {
+		return err
+	}
+	if err := server.listener.SetHandler(objectName, handler); err != nil {
+		return err
+	}
+	server.objectNames = append(server.objectNames, objectName)
+	return nil
 }
 
+// Run 开始监听，设置了 Registry 时注册所有对象名
 func (server *RPCServer) Run() {
-	go server.listener.Run()
+	server.listener.Run()
+	server.register()
 }
 
-func (server *RPCServer) Shutdown() {
-	if server.listener != nil {
-		server.listener.Shutdown()
+// register 将所有对象名注册到注册中心，设置了 RegisterTTL 时定时续期
+func (server *RPCServer) register() {
+	reg := server.option.Registry
+	if reg == nil {
+		return
 	}
+	addr := server.option.AdvertiseAddr
+	if addr == "" {
+		addr = fmt.Sprintf("%s:%d", server.option.Ip, server.option.Port)
+	}
+	for _, objectName := range server.objectNames {
+		ins := registry.Instance{
+			Service:  objectName,
+			Addr:     addr,
+			Metadata: server.option.Metadata,
+			TTL:      server.option.RegisterTTL,
+		}
+		if err := reg.Register(ins); err != nil {
+			log.Printf("register %s to registry error:%+v\n", objectName, err)
+		}
+		server.instances = append(server.instances, ins)
+	}
+	if server.option.RegisterTTL > 0 {
+		server.heartbeatWg.Add(1)
+		go server.heartbeat()
+	}
+}
+
+// heartbeat 定时续期，注册失败（例如注册中心重启）时也会重新注册
+func (server *RPCServer) heartbeat() {
+	defer server.heartbeatWg.Done()
+	// TTL 很小时（小于3纳秒 TTL/3 为0，NewTicker 会 panic）至少间隔1毫秒
+	interval := server.option.RegisterTTL / 3
+	if interval < time.Millisecond {
+		interval = time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for _, ins := range server.instances {
+				if err := server.option.Registry.Register(ins); err != nil {
+					log.Printf("renew %s in registry error:%+v\n", ins.Service, err)
+				}
+			}
+		case <-server.stopHeartbeat:
+			return
+		}
+	}
+}
+
+// deregister 停止续期，从注册中心注销
+func (server *RPCServer) deregister() {
+	server.deregisterOnce.Do(func() {
+		close(server.stopHeartbeat)
+		server.heartbeatWg.Wait()
+		for _, ins := range server.instances {
+			if err := server.option.Registry.Deregister(ins); err != nil {
+				log.Printf("deregister %s from registry error:%+v\n", ins.Service, err)
+			}
+		}
+	})
+}
 
+// Shutdown 优雅关闭服务：先从注册中心注销，客户端不再发送新的请求，再关闭连接；ctx结束时强制关闭
+func (server *RPCServer) Shutdown(ctx context.Context) error {
+	server.deregister()
+	if server.listener != nil {
+		return server.listener.Shutdown(ctx)
+	}
+	return nil
 }
@@ -0,0 +1,74 @@
+package rpcserver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gofish2020/easyrpc/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelfRegistration(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, nil, err)
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	reg := registry.NewMemoryRegistry()
+	option := DefaultOption
+	option.Ip = "127.0.0.1"
+	option.Port = port
+	option.Registry = reg
+	option.RegisterTTL = 60 * time.Millisecond
+	option.Metadata = map[string]string{"version": "v1", "weight": "10", "zone": "sh"}
+
+	server := NewRPCServer(option)
+	assert.Equal(t, nil, server.RegisterByName("Echo", &echoService{}))
+	assert.Equal(t, nil, server.RegisterByName("Math", &mathService{}))
+	server.Run()
+
+	// 超过 TTL 后仍然存在（已续期）
+	time.Sleep(200 * time.Millisecond)
+	for _, name := range []string{"Echo", "Math"} {
+		list, err := reg.List(name)
+		assert.Equal(t, nil, err)
+		if assert.Equal(t, 1, len(list)) {
+			assert.Equal(t, fmt.Sprintf("127.0.0.1:%d", port), list[0].Addr)
+			assert.Equal(t, option.Metadata, list[0].Metadata)
+		}
+	}
+
+	// 关闭服务时注销
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := reg.Watch(ctx, "Echo")
+	assert.Equal(t, nil, err)
+	<-ch
+	assert.Equal(t, nil, server.Shutdown(context.Background()))
+	select {
+	case list := <-ch:
+		assert.Equal(t, 0, len(list))
+	default:
+		t.Fatal("instance is not deregistered")
+	}
+	// 不再续期
+	time.Sleep(100 * time.Millisecond)
+	list, err := reg.List("Math")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(list))
+}
+
+func TestTinyRegisterTTL(t *testing.T) {
+	// TTL/3 为0时不会 panic
+	option := DefaultOption
+	option.Registry = registry.NewMemoryRegistry()
+	option.RegisterTTL = 2 * time.Nanosecond
+	server := NewRPCServer(option)
+	assert.Equal(t, nil, server.RegisterByName("Echo", &echoService{}))
+	server.register()
+	time.Sleep(10 * time.Millisecond)
+	server.deregister()
+}
@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"bytes"
+	"sync"
+)
+
+// 超过该容量的缓冲区不放回池中，避免偶尔的大数据包长期占用内存
+const maxPooledBufferSize = 1 << 20
+
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+// GetBuffer 从池中获取一个空的缓冲区，使用完成后调用 PutBuffer 放回
+// 放回后不能再使用缓冲区的数据，需要返回给调用方的数据要先复制
+func GetBuffer() *bytes.Buffer {
+	return bufferPool.Get().(*bytes.Buffer)
+}
+
+func PutBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBufferSize {
+		return
+	}
+	buf.Reset()
+	bufferPool.Put(buf)
+}
+
+var readerPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Reader)
+	},
+}
+
+// GetReader 从池中获取读取 data 的 Reader，使用完成后调用 PutReader 放回
+func GetReader(data []byte) *bytes.Reader {
+	r := readerPool.Get().(*bytes.Reader)
+	r.Reset(data)
+	return r
+}
+
+func PutReader(r *bytes.Reader) {
+	r.Reset(nil)
+	readerPool.Put(r)
+}
+
+// CopyBytes 复制数据（例如池中缓冲区的数据）
+func CopyBytes(data []byte) []byte {
+	res := make([]byte, len(data))
+	copy(res, data)
+	return res
+}
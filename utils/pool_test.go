@@ -0,0 +1,28 @@
+package utils
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBufferPool(t *testing.T) {
+	buf := GetBuffer()
+	buf.WriteString("easyrpc")
+	data := CopyBytes(buf.Bytes())
+	PutBuffer(buf)
+
+	// 放回后的缓冲区是空的，复制的数据不受影响
+	buf = GetBuffer()
+	if buf.Len() != 0 {
+		t.Fatalf("pooled buffer len %d", buf.Len())
+	}
+	buf.WriteString("overwrite")
+	if !bytes.Equal(data, []byte("easyrpc")) {
+		t.Fatal(string(data))
+	}
+	PutBuffer(buf)
+
+	// 大缓冲区不放回
+	big := bytes.NewBuffer(make([]byte, 0, maxPooledBufferSize+1))
+	PutBuffer(big)
+}